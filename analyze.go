@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"strconv"
+	"strings"
 
 	"github.com/tyru/vain/node"
 )
@@ -46,6 +47,9 @@ func analyze(name string, inNodes <-chan node.Node, ns Namespace) *analyzer {
 		policies,
 		ns,
 		nil,
+		nil,
+		nil,
+		nil,
 	}
 }
 
@@ -58,6 +62,9 @@ type analyzer struct {
 	policies   map[string]bool
 	ns         Namespace
 	nsdb       *NamespaceDB
+	enums      map[string]*enumType // enums declared at top level
+	imports    []node.Node          // names imported at top level
+	warnings   []node.ErrorNode     // warnings of the current top-level node
 }
 
 func (a *analyzer) Nodes() <-chan node.Node {
@@ -74,19 +81,25 @@ func (a *analyzer) enabled(name string) bool {
 
 const (
 	toplevelReturn       = "toplevel-return"
+	loopControlOutside   = "loop-control-outside-loop"
 	undeclaredVariable   = "undeclared-variable"
 	duplicateDeclaration = "duplicate-declaration"
 	// XXX maybe this is unnecessary, because the parser doesn't allow
 	// tokenUnderscore as variable name.
-	underscoreVariableReference = "underscore-variable-reference"
-	convertUnderscoreVariable   = "convert-underscore-variable"
-	assignmentToConstVariable   = "assignment-to-const-variable"
+	underscoreVariableReference  = "underscore-variable-reference"
+	convertUnderscoreVariable    = "convert-underscore-variable"
+	assignmentToConstVariable    = "assignment-to-const-variable"
+	requiredArgumentAfterDefault = "required-argument-after-default"
+	nonExhaustiveEnum            = "non-exhaustive-enum"
 )
 
 var walkFuncs = []multiWalkFn{
 	checkToplevelReturn,
 	checkVariable,
 	convertVariableNames,
+	checkFuncArguments,
+	checkLoopControl,
+	checkEnumExhaustive,
 }
 
 func init() {
@@ -96,6 +109,7 @@ func init() {
 		isChecker   bool
 		isConverter bool
 		enabled     bool
+		isWarning   bool
 	}{
 		{
 			toplevelReturn,
@@ -103,6 +117,15 @@ func init() {
 			true,
 			false,
 			true,
+			false,
+		},
+		{
+			loopControlOutside,
+			4,
+			true,
+			false,
+			true,
+			false,
 		},
 		{
 			undeclaredVariable,
@@ -110,6 +133,7 @@ func init() {
 			true,
 			false,
 			true,
+			false,
 		},
 		{
 			duplicateDeclaration,
@@ -117,6 +141,7 @@ func init() {
 			true,
 			false,
 			true,
+			false,
 		},
 		{
 			underscoreVariableReference,
@@ -124,6 +149,7 @@ func init() {
 			true,
 			false,
 			true,
+			false,
 		},
 		{
 			convertUnderscoreVariable,
@@ -131,6 +157,7 @@ func init() {
 			false,
 			true,
 			true,
+			false,
 		},
 		{
 			assignmentToConstVariable,
@@ -138,13 +165,30 @@ func init() {
 			true,
 			false,
 			true,
+			false,
+		},
+		{
+			requiredArgumentAfterDefault,
+			3,
+			true,
+			false,
+			true,
+			false,
+		},
+		{
+			nonExhaustiveEnum,
+			5,
+			true,
+			false,
+			true,
+			true,
 		},
 	}
 	defaultPolicies = make(map[string]bool, len(def))
 	ruleMap = make(map[string]rule, len(def))
 	for i := range def {
 		defaultPolicies[def[i].name] = def[i].enabled
-		ruleMap[def[i].name] = rule{def[i].funcID, def[i].isChecker, def[i].isConverter}
+		ruleMap[def[i].name] = rule{def[i].funcID, def[i].isChecker, def[i].isConverter, def[i].isWarning}
 	}
 }
 
@@ -157,11 +201,13 @@ type rule struct {
 	funcID      int  // The index number of walkFuncs.
 	isChecker   bool // If true, this is checker function.
 	isConverter bool // If true, this is converter function.
+	isWarning   bool // If true, the errors of this rule are warnings.
 }
 
 type typedNode struct {
 	node.Node
-	typ string // expression type
+	typ  string   // expression type
+	decl typeExpr // type expression with type arguments (e.g. List<Int>), or nil
 }
 
 func (n *typedNode) Clone() node.Node {
@@ -169,20 +215,40 @@ func (n *typedNode) Clone() node.Node {
 	if n.Node != nil {
 		inner = n.Node.Clone()
 	}
-	return &typedNode{inner, n.typ}
+	return &typedNode{inner, n.typ, n.decl}
 }
 
+// Run analyzes the nodes from the parser (see parser.Run()).
+// It sends the errors, or the analyzed top-level node if no error occurred.
+// Warnings are sent before the node or the errors.
 func (a *analyzer) Run(nsdb *NamespaceDB) {
-	a.nsdb = nsdb
+	// Copy nsdb not to modify it, because it may be shared between analyzers.
+	db := make(NamespaceDB, 8)
+	if nsdb != nil {
+		for ns, scope := range *nsdb {
+			db.setNamespace(ns, scope)
+		}
+	}
+	a.nsdb = &db
 	for n := range a.inNodes {
 		if top, ok := n.TerminalNode().(*topLevelNode); ok {
+			if top.partial {
+				// Syntax errors were already emitted by the parser.
+				// Don't report the errors caused by the skipped statements.
+				continue
+			}
 			result, errs := a.analyze(top)
+			for i := range a.warnings {
+				a.emit(&a.warnings[i]) // the result is emitted even if warnings exist
+			}
+			a.warnings = nil
 			if len(errs) > 0 {
 				for i := range errs {
 					a.emit(&errs[i]) // type error, and so on
 				}
 				continue
 			}
+			a.declare(result)
 			a.emit(result)
 		} else if e, ok := n.TerminalNode().(*node.ErrorNode); ok {
 			a.emit(e) // parse error
@@ -200,21 +266,57 @@ func (a *analyzer) emit(n node.Node) {
 }
 
 func (a *analyzer) err(err error, n node.Node) *node.ErrorNode {
-	if pos := n.Position(); pos != nil {
-		return node.NewErrorNode(
-			fmt.Errorf("[analyze] %s:%d:%d: "+err.Error(), a.name, pos.Line(), pos.Col()+1),
-			pos,
-		)
+	return a.ruleErr("", err, n)
+}
+
+// ruleErr returns a positioned error reported by the rule.
+// notes are shown after the error (e.g. the previous declaration).
+func (a *analyzer) ruleErr(rule string, err error, n node.Node, notes ...diagnosticNote) *node.ErrorNode {
+	pos := n.Position()
+	var end *node.Pos
+	if id, ok := n.TerminalNode().(*identifierNode); ok && pos != nil {
+		end = node.NewPos(pos.Offset()+len(id.value), pos.Line(), pos.Col()+len(id.value))
 	}
-	return node.NewErrorNode(
-		fmt.Errorf("[analyze] %s: "+err.Error(), a.name),
-		nil,
-	)
+	d := newDiagnostic("analyze", a.name, pos, end, err.Error())
+	d.rule = rule
+	d.notes = notes
+	if ruleMap[rule].isWarning {
+		d.severity = severityWarning
+	}
+	return node.NewErrorNode(d, pos)
+}
+
+// takeWarnings moves the warnings in errs to a.warnings,
+// and returns the rest of errs.
+func (a *analyzer) takeWarnings(errs []node.ErrorNode) []node.ErrorNode {
+	rest := errs[:0]
+	for i := range errs {
+		if isWarning(&errs[i]) {
+			a.warnings = append(a.warnings, errs[i])
+		} else {
+			rest = append(rest, errs[i])
+		}
+	}
+	return rest
 }
 
 func (a *analyzer) analyze(top *topLevelNode) (node.Node, []node.ErrorNode) {
+	// Enum variants can be referred before the declaration, and from functions.
+	a.enums = make(map[string]*enumType, 8)
+	// Imported names can be referred from functions.
+	a.imports = a.imports[:0]
+	for i := range top.body {
+		switch n := top.body[i].TerminalNode().(type) {
+		case *enumStatement:
+			a.enums[n.typ.name] = n.typ
+		case *importStatement:
+			ids, _ := a.getDeclaredVars(top.body[i])
+			a.imports = append(a.imports, ids...)
+		}
+	}
+
 	// Perform semantics checks.
-	errs := a.check(top)
+	errs := a.takeWarnings(a.check(top))
 	if len(errs) > 0 {
 		return nil, errs
 	}
@@ -267,7 +369,8 @@ func checkToplevelReturn(a *analyzer, ctrl *walkCtrl, n node.Node) (node.Node, [
 		ctrl.dontFollowInner()
 		return n, nil
 	case *returnStatement:
-		err := a.err(
+		err := a.ruleErr(
+			toplevelReturn,
 			errors.New("return statement found at top level"),
 			n,
 		)
@@ -277,30 +380,347 @@ func checkToplevelReturn(a *analyzer, ctrl *walkCtrl, n node.Node) (node.Node, [
 	}
 }
 
+// checkEnumExhaustive checks if an if-else chain or a match statement
+// over the enum value covers all variants of the enum.
+// The conditions of the chain must compare the same variable with
+// the variants (e.g. "m == Mode.Normal || m == Mode.Insert").
+// A single if statement and a chain with else clause are not checked.
+// A match statement which has the arm of "_" or a variable is exhaustive.
+func checkEnumExhaustive(a *analyzer, ctrl *walkCtrl, n node.Node) (node.Node, []node.ErrorNode) {
+	switch nn := n.TerminalNode().(type) {
+	case *topLevelNode:
+		return n, a.checkEnumExhaustive(nn.body)
+	case *funcStmtOrExpr:
+		return n, a.checkEnumExhaustive(nn.body)
+	default:
+		return n, nil
+	}
+}
+
+func (a *analyzer) checkEnumExhaustive(body []node.Node) []node.ErrorNode {
+	errs := make([]node.ErrorNode, 0, 4)
+	elseIfs := make(map[*ifStatement]bool, 8)
+	for i := range body {
+		walkNode(body[i], func(ctrl *walkCtrl, n node.Node) node.Node {
+			switch nn := n.TerminalNode().(type) {
+			case *funcStmtOrExpr:
+				ctrl.dontFollowInner() // skip another function.
+			case *ifStatement:
+				if elseIfs[nn] {
+					break // checked as the part of the chain.
+				}
+				conds := make([]node.Node, 0, 4)
+				hasElse := false
+				for cur := nn; ; {
+					conds = append(conds, cur.cond)
+					if len(cur.els) == 1 {
+						if next, ok := cur.els[0].TerminalNode().(*ifStatement); ok {
+							elseIfs[next] = true
+							cur = next
+							continue
+						}
+					}
+					hasElse = len(cur.els) > 0
+					break
+				}
+				if hasElse || len(conds) < 2 {
+					break
+				}
+				if missing, e := a.getMissingVariants(conds); len(missing) > 0 {
+					err := a.ruleErr(nonExhaustiveEnum, fmt.Errorf(
+						"non-exhaustive if-else chain over %s: missing %s", e.name, strings.Join(missing, ", "),
+					), n)
+					errs = append(errs, *err)
+				}
+			case *matchStatement:
+				if missing, e := a.getMissingMatchVariants(nn); len(missing) > 0 {
+					err := a.ruleErr(nonExhaustiveEnum, fmt.Errorf(
+						"non-exhaustive match over %s: missing %s", e.name, strings.Join(missing, ", "),
+					), n)
+					errs = append(errs, *err)
+				}
+			}
+			return n
+		})
+	}
+	return errs
+}
+
+// getMissingVariants returns the variants which are not compared in conds.
+// If conds do not compare the same variable with the variants of one enum,
+// returns nil.
+func (a *analyzer) getMissingVariants(conds []node.Node) ([]string, *enumType) {
+	var subject string
+	var enum *enumType
+	covered := make(map[string]bool, 8)
+	for i := range conds {
+		s, e, variants := a.getEnumComparison(conds[i])
+		if e == nil || (enum != nil && (e != enum || s != subject)) {
+			return nil, nil
+		}
+		subject, enum = s, e
+		for _, v := range variants {
+			covered[v] = true
+		}
+	}
+	missing := make([]string, 0, len(enum.variants))
+	for _, v := range enum.variants {
+		if !covered[v.name] {
+			missing = append(missing, enum.name+"."+v.name)
+		}
+	}
+	return missing, enum
+}
+
+// getMissingMatchVariants returns the variants which are not matched by
+// the arms of n.
+// If the patterns are not the variants of one enum, returns nil.
+func (a *analyzer) getMissingMatchVariants(n *matchStatement) ([]string, *enumType) {
+	var enum *enumType
+	covered := make(map[string]bool, 8)
+	for i := range n.arms {
+		if _, ok := n.arms[i].pattern.TerminalNode().(*identifierNode); ok {
+			return nil, nil // matches any value
+		}
+		e := a.getEnumVariant(n.arms[i].pattern)
+		if e == nil || (enum != nil && e != enum) {
+			return nil, nil
+		}
+		enum = e
+		dot := n.arms[i].pattern.TerminalNode().(*dotNode)
+		covered[dot.right.TerminalNode().(*identifierNode).value] = true
+	}
+	missing := make([]string, 0, len(enum.variants))
+	for _, v := range enum.variants {
+		if !covered[v.name] {
+			missing = append(missing, enum.name+"."+v.name)
+		}
+	}
+	return missing, enum
+}
+
+// getEnumComparison returns the compared variable, the enum, and the variants
+// if cond is "subject == Enum.Variant" or the disjunction of them.
+// Otherwise, returns nil enum.
+func (a *analyzer) getEnumComparison(cond node.Node) (string, *enumType, []string) {
+	switch nn := cond.TerminalNode().(type) {
+	case *orNode:
+		ls, le, lv := a.getEnumComparison(nn.left)
+		rs, re, rv := a.getEnumComparison(nn.right)
+		if le == nil || le != re || ls != rs {
+			return "", nil, nil
+		}
+		return ls, le, append(lv, rv...)
+	case *equalNode:
+		subject, variant := nn.left, nn.right
+		if a.getEnumVariant(subject) != nil {
+			subject, variant = variant, subject
+		}
+		dot, _ := variant.TerminalNode().(*dotNode)
+		e := a.getEnumVariant(variant)
+		s := subjectString(subject)
+		if e == nil || s == "" {
+			return "", nil, nil
+		}
+		return s, e, []string{dot.right.TerminalNode().(*identifierNode).value}
+	}
+	return "", nil, nil
+}
+
+// getEnumVariant returns the enum if n is "Enum.Variant".
+func (a *analyzer) getEnumVariant(n node.Node) *enumType {
+	dot, ok := n.TerminalNode().(*dotNode)
+	if !ok {
+		return nil
+	}
+	enum, ok := dot.left.TerminalNode().(*identifierNode)
+	if !ok {
+		return nil
+	}
+	variant, ok := dot.right.TerminalNode().(*identifierNode)
+	if !ok || a.enums[enum.value] == nil || a.enums[enum.value].getVariant(variant.value) == nil {
+		return nil
+	}
+	return a.enums[enum.value]
+}
+
+// subjectString returns the string to identify the variable
+// (e.g. "a", "a.b.c"). If n is not a variable, returns "".
+func subjectString(n node.Node) string {
+	switch nn := n.TerminalNode().(type) {
+	case *identifierNode:
+		return nn.value
+	case *dotNode:
+		left := subjectString(nn.left)
+		right, ok := nn.right.TerminalNode().(*identifierNode)
+		if left == "" || !ok {
+			return ""
+		}
+		return left + "." + right.value
+	}
+	return ""
+}
+
+// checkLoopControl checks if breakStatement or continueStatement exists
+// outside while and for statement.
+// A function inside a loop is not a loop, so it is checked separately.
+func checkLoopControl(a *analyzer, ctrl *walkCtrl, n node.Node) (node.Node, []node.ErrorNode) {
+	switch nn := n.TerminalNode().(type) {
+	case *topLevelNode:
+		return n, a.checkLoopControl(nn.body)
+	case *funcStmtOrExpr:
+		return n, a.checkLoopControl(nn.body)
+	default:
+		return n, nil
+	}
+}
+
+func (a *analyzer) checkLoopControl(body []node.Node) []node.ErrorNode {
+	errs := make([]node.ErrorNode, 0, 4)
+	loopRoutes := make([][]int, 0, 8)
+	for i := range body {
+		walkNode(body[i], func(ctrl *walkCtrl, n node.Node) node.Node {
+			var stmt string
+			switch n.TerminalNode().(type) {
+			case *funcStmtOrExpr:
+				ctrl.dontFollowInner() // skip another function.
+				return n
+			case *whileStatement, *forStatement:
+				loopRoutes = append(loopRoutes, ctrl.route())
+				return n
+			case *breakStatement:
+				stmt = "break"
+			case *continueStatement:
+				stmt = "continue"
+			default:
+				return n
+			}
+			if !containsRoute(ctrl.route(), loopRoutes) {
+				err := a.ruleErr(
+					loopControlOutside,
+					fmt.Errorf("%s statement found outside loop", stmt),
+					n,
+				)
+				errs = append(errs, *err)
+			}
+			return n
+		})
+		loopRoutes = loopRoutes[:0]
+	}
+	return errs
+}
+
+// checkFuncArguments checks if a required argument follows
+// a default argument, e.g. "func f(a = 1, b: Int)".
+func checkFuncArguments(a *analyzer, ctrl *walkCtrl, n node.Node) (node.Node, []node.ErrorNode) {
+	// funcStmtOrExpr is checked by its declare node.
+	f, ok := n.TerminalNode().(*funcDeclareStatement)
+	if !ok {
+		return n, nil
+	}
+	var defaultArg string
+	for i := range f.args {
+		id, ok := f.args[i].left.TerminalNode().(*identifierNode)
+		if !ok {
+			continue
+		}
+		if f.args[i].defaultVal != nil {
+			defaultArg = id.value
+		} else if defaultArg != "" && !f.args[i].variadic {
+			err := a.ruleErr(
+				requiredArgumentAfterDefault,
+				fmt.Errorf("required argument %s follows default argument %s", id.value, defaultArg),
+				f.args[i].left,
+			)
+			return n, []node.ErrorNode{*err}
+		}
+	}
+	return n, nil
+}
+
 // NamespaceDB holds namespaces.
-type NamespaceDB map[string]*Namespace
+type NamespaceDB map[Namespace]*Scope
 
-// getNamespace gets a namespace.
-func (db NamespaceDB) getNamespace(name string) *Namespace {
-	return db[name]
+// getNamespace gets the scope of a namespace.
+// If the namespace does not exist, returns nil.
+func (db NamespaceDB) getNamespace(ns Namespace) *Scope {
+	return db[ns]
 }
 
-// setNamespace sets a namespace.
-func (db NamespaceDB) setNamespace(ns *Namespace) {
-	db[string(*ns)] = ns
+// setNamespace sets the scope of a namespace.
+func (db NamespaceDB) setNamespace(ns Namespace, scope *Scope) {
+	db[ns] = scope
 }
 
-// Namespace holds scopes.
+// getFunc gets the function signature declared in the namespace.
+func (db NamespaceDB) getFunc(ns Namespace, name string) *funcDeclareStatement {
+	if scope := db.getNamespace(ns); scope != nil {
+		return scope.getOuterFunc(name)
+	}
+	return nil
+}
+
+// Namespace is the name of scopes.
 type Namespace string
 
 // ToplevelNamespace is the top level namespace constant.
 const ToplevelNamespace = Namespace("")
 
+// BuiltinNamespace is the namespace of the standard library ($VAINROOT/lib).
+// The functions in this namespace can be called without declarations.
+const BuiltinNamespace = Namespace("$vim")
+
+// declare registers the functions declared at the top level to a.nsdb.
+func (a *analyzer) declare(top node.Node) {
+	scope := a.nsdb.getNamespace(a.ns)
+	if scope == nil {
+		scope = NewScope()
+		scope.push()
+		a.nsdb.setNamespace(a.ns, scope)
+	}
+	tl, ok := top.TerminalNode().(*topLevelNode)
+	if !ok {
+		return
+	}
+	for i := range tl.body {
+		var f *funcDeclareStatement
+		switch nn := tl.body[i].TerminalNode().(type) {
+		case *funcDeclareStatement:
+			f = nn
+		case *funcStmtOrExpr:
+			if nn.isExpr {
+				continue
+			}
+			f = nn.declare
+		default:
+			continue
+		}
+		if f.name != "" {
+			scope.addConstVar(&identifierNode{f.name, true})
+			scope.setFunc(f.name, f)
+		}
+	}
+}
+
+// getBuiltinFunc gets the function signature declared in the standard library.
+func (a *analyzer) getBuiltinFunc(name string) *funcDeclareStatement {
+	if a.nsdb == nil {
+		return nil
+	}
+	return a.nsdb.getFunc(BuiltinNamespace, name)
+}
+
 // NewScope is the constructor for Scope.
 func NewScope() *Scope {
 	return &Scope{
 		make([]map[string]*identifierNode, 0, 4),
 		make([]map[string]bool, 0, 4),
+		make([]map[string]*node.Pos, 0, 4),
+		make([]map[string]string, 0, 4),
+		make([]map[string]*funcDeclareStatement, 0, 4),
+		make([]map[string]typeExpr, 0, 4),
+		make([]map[string]typeExpr, 0, 4),
+		make([]map[string]string, 0, 4),
 	}
 }
 
@@ -308,16 +728,34 @@ func NewScope() *Scope {
 type Scope struct {
 	vars    []map[string]*identifierNode
 	isConst []map[string]bool
+	pos     []map[string]*node.Pos             // The positions of declarations.
+	types   []map[string]string                // Used only by type inference.
+	funcs   []map[string]*funcDeclareStatement // Used only by type inference.
+	decls   []map[string]typeExpr              // Used only by type inference.
+	typedef []map[string]typeExpr              // Used only by type inference.
+	pkgs    []map[string]string                // Import paths of packages. Used only by type inference.
 }
 
 func (s *Scope) push() {
 	s.vars = append(s.vars, make(map[string]*identifierNode, 8))
 	s.isConst = append(s.isConst, make(map[string]bool, 8))
+	s.pos = append(s.pos, make(map[string]*node.Pos, 8))
+	s.types = append(s.types, make(map[string]string, 8))
+	s.funcs = append(s.funcs, make(map[string]*funcDeclareStatement, 8))
+	s.decls = append(s.decls, make(map[string]typeExpr, 8))
+	s.typedef = append(s.typedef, make(map[string]typeExpr, 8))
+	s.pkgs = append(s.pkgs, make(map[string]string, 8))
 }
 
 func (s *Scope) pop() {
 	s.vars = s.vars[:len(s.vars)-1]
 	s.isConst = s.isConst[:len(s.isConst)-1]
+	s.pos = s.pos[:len(s.pos)-1]
+	s.types = s.types[:len(s.types)-1]
+	s.funcs = s.funcs[:len(s.funcs)-1]
+	s.decls = s.decls[:len(s.decls)-1]
+	s.typedef = s.typedef[:len(s.typedef)-1]
+	s.pkgs = s.pkgs[:len(s.pkgs)-1]
 }
 
 func (s *Scope) getVar(name string) (id *identifierNode, isConst bool) {
@@ -347,6 +785,186 @@ func (s *Scope) addConstVar(id *identifierNode) {
 	s.isConst[len(s.vars)-1][id.value] = true
 }
 
+// setDeclaredPos sets the position of the variable declaration in the current scope.
+func (s *Scope) setDeclaredPos(name string, pos *node.Pos) {
+	if pos != nil {
+		s.pos[len(s.pos)-1][name] = pos
+	}
+}
+
+// getDeclaredPos returns the position of the variable declaration in the current scope.
+// It returns nil if the position is unknown.
+func (s *Scope) getDeclaredPos(name string) *node.Pos {
+	return s.pos[len(s.pos)-1][name]
+}
+
+// setType sets the type of the variable in the current scope.
+func (s *Scope) setType(name, typ string) {
+	s.types[len(s.types)-1][name] = typ
+	delete(s.decls[len(s.decls)-1], name)
+	delete(s.pkgs[len(s.pkgs)-1], name)
+}
+
+// setDeclaredType sets the declared type of the variable in the current scope.
+// The type of the variable is set to the type converted by declaredType().
+func (s *Scope) setDeclaredType(name string, t typeExpr) {
+	s.types[len(s.types)-1][name] = declaredType(t)
+	s.decls[len(s.decls)-1][name] = t
+}
+
+// getOuterDeclaredType returns the declared type of the variable.
+// If the type of the variable is not declared, returns nil.
+func (s *Scope) getOuterDeclaredType(name string) typeExpr {
+	for i := len(s.types) - 1; i >= 0; i-- {
+		if _, ok := s.types[i][name]; ok {
+			// Shadowed by the variable of inner scope.
+			return s.decls[i][name]
+		}
+	}
+	return nil
+}
+
+// setTypeDef sets the type declared by "type" statement in the current scope.
+func (s *Scope) setTypeDef(name string, t typeExpr) {
+	s.typedef[len(s.typedef)-1][name] = t
+}
+
+// getTypeDef returns the type declared in the current scope.
+func (s *Scope) getTypeDef(name string) typeExpr {
+	return s.typedef[len(s.typedef)-1][name]
+}
+
+// getOuterTypeDef returns the type declared by "type" statement.
+func (s *Scope) getOuterTypeDef(name string) typeExpr {
+	for i := len(s.typedef) - 1; i >= 0; i-- {
+		if t, ok := s.typedef[i][name]; ok {
+			return t
+		}
+	}
+	return nil
+}
+
+// resolveType replaces the names of declared types in t with their definitions.
+// The field types of record types are not resolved
+// because record types can be recursive.
+func (s *Scope) resolveType(t typeExpr) typeExpr {
+	switch tt := t.(type) {
+	case *namedType:
+		if def := s.getOuterTypeDef(tt.name); def != nil {
+			return def
+		}
+	case *listType:
+		return &listType{s.resolveType(tt.elem)}
+	case *dictType:
+		return &dictType{s.resolveType(tt.value)}
+	case *funcType:
+		args := make([]typeExpr, len(tt.args))
+		for i := range tt.args {
+			args[i] = s.resolveType(tt.args[i])
+		}
+		var ret typeExpr
+		if tt.ret != nil {
+			ret = s.resolveType(tt.ret)
+		}
+		return &funcType{args, ret}
+	case *optionalType:
+		return &optionalType{s.resolveType(tt.elem)}
+	case *unionType:
+		types := make([]typeExpr, len(tt.types))
+		for i := range tt.types {
+			types[i] = s.resolveType(tt.types[i])
+		}
+		return &unionType{types}
+	}
+	return t
+}
+
+// undefinedType returns the name of the first undefined type in t.
+// If all types are defined, returns "".
+func (s *Scope) undefinedType(t typeExpr) string {
+	switch tt := t.(type) {
+	case *namedType:
+		if !isBuiltinType(tt.name) && s.getOuterTypeDef(tt.name) == nil {
+			return tt.name
+		}
+	case *listType:
+		return s.undefinedType(tt.elem)
+	case *dictType:
+		return s.undefinedType(tt.value)
+	case *funcType:
+		for i := range tt.args {
+			if name := s.undefinedType(tt.args[i]); name != "" {
+				return name
+			}
+		}
+		if tt.ret != nil {
+			return s.undefinedType(tt.ret)
+		}
+	case *optionalType:
+		return s.undefinedType(tt.elem)
+	case *unionType:
+		for i := range tt.types {
+			if name := s.undefinedType(tt.types[i]); name != "" {
+				return name
+			}
+		}
+	case *recordType:
+		for i := range tt.fields {
+			if name := s.undefinedType(tt.fields[i].typ); name != "" {
+				return name
+			}
+		}
+	}
+	return ""
+}
+
+// getOuterType returns the type of the variable.
+// If the variable is not found, returns typeUnknown.
+func (s *Scope) getOuterType(name string) (typ string, found bool) {
+	for i := len(s.types) - 1; i >= 0; i-- {
+		if t, ok := s.types[i][name]; ok {
+			return t, true
+		}
+	}
+	return typeUnknown, false
+}
+
+// setFunc sets the function signature of the variable in the current scope.
+// The type of the variable is set to typeFunc.
+func (s *Scope) setFunc(name string, f *funcDeclareStatement) {
+	s.types[len(s.types)-1][name] = typeFunc
+	s.funcs[len(s.funcs)-1][name] = f
+}
+
+// setPackage sets the variable of the package imported by "import path".
+func (s *Scope) setPackage(name, path string) {
+	s.setType(name, typeUnknown)
+	s.pkgs[len(s.pkgs)-1][name] = path
+}
+
+// getOuterPackage returns the import path of the package variable.
+// If the variable is not a package, returns "".
+func (s *Scope) getOuterPackage(name string) string {
+	for i := len(s.pkgs) - 1; i >= 0; i-- {
+		if _, ok := s.types[i][name]; ok {
+			// Shadowed by the variable of inner scope.
+			return s.pkgs[i][name]
+		}
+	}
+	return ""
+}
+
+// getOuterFunc returns the function signature of the variable.
+func (s *Scope) getOuterFunc(name string) *funcDeclareStatement {
+	for i := len(s.funcs) - 1; i >= 0; i-- {
+		if _, ok := s.types[i][name]; ok {
+			// Shadowed by the variable of inner scope.
+			return s.funcs[i][name]
+		}
+	}
+	return nil
+}
+
 // checkVariable checks:
 // * toplevel-return
 //   * Variables are used before declaration.
@@ -359,15 +977,46 @@ func checkVariable(a *analyzer, _ *walkCtrl, n node.Node) (node.Node, []node.Err
 	case *topLevelNode:
 		return n, a.checkVariable(nn.body, NewScope())
 	case *funcStmtOrExpr:
-		return n, a.checkVariable(nn.body, NewScope())
+		scope := NewScope()
+		scope.push()
+		for i := range a.imports {
+			if id, ok := a.imports[i].TerminalNode().(*identifierNode); ok {
+				scope.addConstVar(id)
+			}
+		}
+		scope.push()
+		for i := range nn.declare.args {
+			if id, ok := nn.declare.args[i].left.TerminalNode().(*identifierNode); ok {
+				scope.addVar(id)
+				scope.setDeclaredPos(id.value, nn.declare.args[i].left.Position())
+			}
+		}
+		return n, a.checkVariable(nn.body, scope)
 	default:
 		return n, nil
 	}
 }
 
+// previousDeclaration returns the note pointing to the previous declaration of
+// the variable in the current scope, if its position is known.
+func previousDeclaration(scope *Scope, name string) []diagnosticNote {
+	if pos := scope.getDeclaredPos(name); pos != nil {
+		return []diagnosticNote{{pos, "previous declaration of " + name + " is here"}}
+	}
+	return nil
+}
+
 // Check the scope of the function, but won't check another function's scope.
 func (a *analyzer) checkVariable(body []node.Node, scope *Scope) []node.ErrorNode {
 	errs := make([]node.ErrorNode, 0, 4)
+	// Functions can be called before the definition.
+	scope.push()
+	for i := range body {
+		if f, ok := body[i].TerminalNode().(*funcStmtOrExpr); ok && !f.isExpr && f.declare.name != "" {
+			scope.addConstVar(&identifierNode{f.declare.name, true})
+			scope.setDeclaredPos(f.declare.name, body[i].Position())
+		}
+	}
 	scope.push()
 	for i := range body {
 		if _, ok := body[i].TerminalNode().(*funcStmtOrExpr); ok {
@@ -383,13 +1032,11 @@ func (a *analyzer) checkVariable(body []node.Node, scope *Scope) []node.ErrorNod
 				}
 				if v, _ := scope.getVar(id.value); v != nil {
 					if a.enabled(duplicateDeclaration) {
-						var declared string
-						if pos := v.Position(); pos != nil {
-							declared = fmt.Sprintf(": already declared at (%d,%d)", pos.Line(), pos.Col()+1)
-						}
-						err := a.err(
-							fmt.Errorf("duplicate variable: %s%s", id.value, declared),
+						err := a.ruleErr(
+							duplicateDeclaration,
+							fmt.Errorf("duplicate variable: %s", id.value),
 							vs[i],
+							previousDeclaration(scope, id.value)...,
 						)
 						errs = append(errs, *err)
 					}
@@ -401,6 +1048,7 @@ func (a *analyzer) checkVariable(body []node.Node, scope *Scope) []node.ErrorNod
 					} else {
 						scope.addVar(id)
 					}
+					scope.setDeclaredPos(id.value, vs[i].Position())
 				}
 			}
 		}
@@ -417,15 +1065,19 @@ func (a *analyzer) checkVariable(body []node.Node, scope *Scope) []node.ErrorNod
 				continue
 			}
 			v, isConst := scope.getOuterVar(id.value)
-			if v == nil && a.enabled(undeclaredVariable) {
-				err := a.err(
+			if v == nil && a.enums[id.value] != nil {
+				isConst = true // enum is not a variable but is not undefined
+			} else if v == nil && a.getBuiltinFunc(id.value) == nil && a.enabled(undeclaredVariable) {
+				err := a.ruleErr(
+					undeclaredVariable,
 					errors.New("undefined: "+id.value),
 					vs[i],
 				)
 				errs = append(errs, *err)
 			}
-			if assigned[i] && v != nil && isConst && a.enabled(assignmentToConstVariable) {
-				err := a.err(
+			if assigned[i] && isConst && a.enabled(assignmentToConstVariable) {
+				err := a.ruleErr(
+					assignmentToConstVariable,
 					errors.New("assignment to const variable: "+id.value),
 					vs[i],
 				)
@@ -434,6 +1086,7 @@ func (a *analyzer) checkVariable(body []node.Node, scope *Scope) []node.ErrorNod
 		}
 	}
 	scope.pop()
+	scope.pop()
 	return errs
 }
 
@@ -445,6 +1098,47 @@ func (a *analyzer) checkInnerBlock(n node.Node, scope *Scope) []node.ErrorNode {
 		return a.checkVariable(nn.body, scope)
 	case *forStatement:
 		return a.checkVariable(nn.body, scope)
+	case *tryStatement:
+		errs := a.checkVariable(nn.body, scope)
+		for i := range nn.catches {
+			scope.push()
+			if nn.catches[i].left != nil {
+				if id, ok := nn.catches[i].left.TerminalNode().(*identifierNode); ok {
+					scope.addVar(id)
+				}
+			}
+			errs = append(errs, a.checkVariable(nn.catches[i].body, scope)...)
+			scope.pop()
+		}
+		return append(errs, a.checkVariable(nn.finally, scope)...)
+	case *matchStatement:
+		errs := a.checkVariable([]node.Node{nn.left}, scope)
+		for i := range nn.arms {
+			scope.push()
+			for _, v := range getPatternIdentifiers(nn.arms[i].pattern) {
+				id, ok := v.TerminalNode().(*identifierNode)
+				if !ok {
+					continue
+				}
+				if declared, _ := scope.getVar(id.value); declared != nil {
+					if a.enabled(duplicateDeclaration) {
+						err := a.ruleErr(
+							duplicateDeclaration,
+							fmt.Errorf("duplicate variable in pattern: %s", id.value),
+							v,
+							previousDeclaration(scope, id.value)...,
+						)
+						errs = append(errs, *err)
+					}
+					continue
+				}
+				scope.addVar(id)
+				scope.setDeclaredPos(id.value, v.Position())
+			}
+			errs = append(errs, a.checkVariable(nn.arms[i].body, scope)...)
+			scope.pop()
+		}
+		return errs
 	default:
 		return nil
 	}
@@ -458,7 +1152,7 @@ func (a *analyzer) getDeclaredVars(n node.Node) ([]node.Node, bool) {
 	case assignNode:
 		isConst := false
 		switch nn.(type) {
-		case *assignExpr:
+		case *assignExpr, *compoundAssignExpr:
 			// *assignExpr is assignNode, but is not a declaration!
 			return nil, false
 		case *constStatement:
@@ -473,6 +1167,24 @@ func (a *analyzer) getDeclaredVars(n node.Node) ([]node.Node, bool) {
 			id = node.NewPosNode(pos, id)
 		}
 		return []node.Node{id}, false
+	case *importStatement:
+		var names []string
+		if len(nn.fnlist) == 0 {
+			names = []string{nn.pkgName()}
+		} else {
+			for _, pair := range nn.importedNames() {
+				names = append(names, pair[1])
+			}
+		}
+		ids := make([]node.Node, 0, len(names))
+		for i := range names {
+			var id node.Node = &identifierNode{names[i], true}
+			if pos := n.Position(); pos != nil {
+				id = node.NewPosNode(pos, id)
+			}
+			ids = append(ids, id)
+		}
+		return ids, true
 	default:
 		return nil, false
 	}
@@ -487,13 +1199,18 @@ func (a *analyzer) getReferenceVars(n node.Node) ([]node.Node, []bool, []node.Er
 	assigned := make([]bool, 0, 8)
 	declRoutes := make([][]int, 0, 8)
 	assignRoutes := make([][]int, 0, 8)
+	keyRoutes := make([][]int, 0, 8)
 	walkNode(n, func(ctrl *walkCtrl, n node.Node) node.Node {
 		switch nn := n.TerminalNode().(type) {
 		case *funcStmtOrExpr:
 			ctrl.dontFollowInner() // skip another function.
 		case *funcDeclareStatement:
 			ctrl.dontFollowInner() // skip another function.
-		case *assignExpr:
+		case *tryStatement:
+			ctrl.dontFollowInner() // blocks are checked by checkInnerBlock().
+		case *matchStatement:
+			ctrl.dontFollowInner() // arms are checked by checkInnerBlock().
+		case *assignExpr, *compoundAssignExpr:
 			// *assignExpr is assignNode, but is not a declaration!
 			lhs := append(ctrl.route(), 0)
 			assignRoutes = append(assignRoutes, lhs)
@@ -503,13 +1220,23 @@ func (a *analyzer) getReferenceVars(n node.Node) ([]node.Node, []bool, []node.Er
 		case *letDeclareStatement:
 			lhs := append(ctrl.route(), 0)
 			declRoutes = append(declRoutes, lhs)
+		case *dictionaryNode:
+			// An identifier key is a string literal, not a variable.
+			for i := range nn.value {
+				if _, ok := nn.value[i][0].TerminalNode().(*identifierNode); ok {
+					key := append(append([]int{}, ctrl.route()...), i, 0)
+					keyRoutes = append(keyRoutes, key)
+				}
+			}
 		case *identifierNode:
 			// The identifierNode is used for variable name, and
 			// is not in left-hand side of declaration node.
-			if nn.isVarname && !containsRoute(ctrl.route(), declRoutes) {
+			if nn.isVarname && !containsRoute(ctrl.route(), declRoutes) &&
+				!containsRoute(ctrl.route(), keyRoutes) {
 				if nn.value == "_" {
 					if a.enabled(underscoreVariableReference) {
-						err := a.err(
+						err := a.ruleErr(
+							underscoreVariableReference,
 							errors.New("underscore variable can be used only in declaration"),
 							n,
 						)
@@ -574,6 +1301,10 @@ func (a *analyzer) convertVariableNames(body []node.Node, scope *Scope) {
 				return n
 			case assignNode:
 				ids = nn.GetLeftIdentifiers()
+			case *matchStatement:
+				for i := range nn.arms {
+					ids = append(ids, getPatternIdentifiers(nn.arms[i].pattern)...)
+				}
 			default:
 				return n
 			}
@@ -594,14 +1325,6 @@ func (a *analyzer) convertVariableNames(body []node.Node, scope *Scope) {
 	}
 }
 
-// infer infers each node's type and return the tree of *typedNode.
-func (a *analyzer) infer(top node.Node) (*typedNode, []node.ErrorNode) {
-	typedTop := walkNode(top, func(_ *walkCtrl, n node.Node) node.Node {
-		return &typedNode{n.Clone(), ""} // TODO
-	}).(*typedNode) // returned node must be *topLevelNode
-	return typedTop, nil
-}
-
 // unwrapNode converts *typedNode to *topLevelNode.
 func (a *analyzer) unwrapNode(tNode *typedNode) (*topLevelNode, *node.ErrorNode) {
 	top, ok := walkNode(tNode, func(_ *walkCtrl, n node.Node) node.Node {
@@ -712,6 +1435,9 @@ func (ctrl *walkCtrl) walk(n node.Node, id int, f func(*walkCtrl, node.Node) nod
 	case *assignExpr:
 		nn.left = ctrl.walk(nn.left, 0, f)
 		nn.right = ctrl.walk(nn.right, 1, f)
+	case *compoundAssignExpr:
+		nn.left = ctrl.walk(nn.left, 0, f)
+		nn.right = ctrl.walk(nn.right, 1, f)
 	case *ifStatement:
 		nn.cond = ctrl.walk(nn.cond, 0, f)
 		ctrl.push(1)
@@ -739,6 +1465,41 @@ func (ctrl *walkCtrl) walk(n node.Node, id int, f func(*walkCtrl, node.Node) nod
 			nn.body[i] = ctrl.walk(nn.body[i], i, f)
 		}
 		ctrl.pop()
+	case *tryStatement:
+		ctrl.push(0)
+		for i := range nn.body {
+			nn.body[i] = ctrl.walk(nn.body[i], i, f)
+		}
+		ctrl.pop()
+		for i := range nn.catches {
+			ctrl.push(i + 1)
+			nn.catches[i].left = ctrl.walk(nn.catches[i].left, 0, f)
+			ctrl.push(1)
+			for j := range nn.catches[i].body {
+				nn.catches[i].body[j] = ctrl.walk(nn.catches[i].body[j], j, f)
+			}
+			ctrl.pop()
+			ctrl.pop()
+		}
+		ctrl.push(len(nn.catches) + 1)
+		for i := range nn.finally {
+			nn.finally[i] = ctrl.walk(nn.finally[i], i, f)
+		}
+		ctrl.pop()
+	case *throwStatement:
+		nn.left = ctrl.walk(nn.left, 0, f)
+	case *matchStatement:
+		nn.left = ctrl.walk(nn.left, 0, f)
+		for i := range nn.arms {
+			ctrl.push(i + 1)
+			nn.arms[i].pattern = ctrl.walk(nn.arms[i].pattern, 0, f)
+			ctrl.push(1)
+			for j := range nn.arms[i].body {
+				nn.arms[i].body[j] = ctrl.walk(nn.arms[i].body[j], j, f)
+			}
+			ctrl.pop()
+			ctrl.pop()
+		}
 	case *ternaryNode:
 		nn.cond = ctrl.walk(nn.cond, 0, f)
 		nn.left = ctrl.walk(nn.left, 1, f)
@@ -815,6 +1576,9 @@ func (ctrl *walkCtrl) walk(n node.Node, id int, f func(*walkCtrl, node.Node) nod
 	case *subtractNode:
 		nn.left = ctrl.walk(nn.left, 0, f)
 		nn.right = ctrl.walk(nn.right, 1, f)
+	case *concatNode:
+		nn.left = ctrl.walk(nn.left, 0, f)
+		nn.right = ctrl.walk(nn.right, 1, f)
 	case *multiplyNode:
 		nn.left = ctrl.walk(nn.left, 0, f)
 		nn.right = ctrl.walk(nn.right, 1, f)
@@ -853,6 +1617,29 @@ func (ctrl *walkCtrl) walk(n node.Node, id int, f func(*walkCtrl, node.Node) nod
 	case *identifierNode:
 	case *intNode:
 	case *floatNode:
+	case *boolNode:
+	case *noneNode:
+	case *breakStatement:
+	case *continueStatement:
+	case *vimBlockStatement:
+	case *typeDeclareStatement:
+	case *enumStatement:
+	case *executeStatement:
+		nn.left = ctrl.walk(nn.left, 0, f)
+	case *templateNode:
+		for i := range nn.exprs {
+			nn.exprs[i] = ctrl.walk(nn.exprs[i], i, f)
+		}
+	case *mapStatement:
+		nn.callback = ctrl.walk(nn.callback, 0, f)
+	case *commandStatement:
+		nn.callback = ctrl.walk(nn.callback, 0, f)
+	case *autocmdStatement:
+		nn.callback = ctrl.walk(nn.callback, 0, f)
+	case *augroupStatement:
+		for i := range nn.body {
+			nn.body[i] = ctrl.walk(nn.body[i], i, f)
+		}
 	case *stringNode:
 	case *listNode:
 		for i := range nn.value {
@@ -896,6 +1683,9 @@ func newMultiWalker(callbacks ...multiWalkFn) *multiWalker {
 
 func containsRoute(r []int, routes [][]int) bool {
 	for i := range routes {
+		if len(r) < len(routes[i]) {
+			continue
+		}
 		contains := true
 		for j := range routes[i] {
 			if r[j] != routes[i][j] {
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/tyru/vain/node"
+)
+
+// severity is the severity of a diagnostic.
+type severity int
+
+const (
+	severityError severity = iota
+	severityWarning
+)
+
+func (s severity) String() string {
+	if s == severityWarning {
+		return "warning"
+	}
+	return "error"
+}
+
+// diagnosticsFormat is the output format of diagnostics.
+type diagnosticsFormat int
+
+const (
+	diagnosticsText     diagnosticsFormat = iota // The messages with the source code (default)
+	diagnosticsJSON                              // A JSON object per line
+	diagnosticsQuickfix                          // The lines for Vim's 'errorformat'
+)
+
+// parseDiagnosticsFormat parses the value of --diagnostics option.
+func parseDiagnosticsFormat(s string) (diagnosticsFormat, error) {
+	switch s {
+	case "text":
+		return diagnosticsText, nil
+	case "json":
+		return diagnosticsJSON, nil
+	case "quickfix":
+		return diagnosticsQuickfix, nil
+	}
+	return diagnosticsText, fmt.Errorf("unknown diagnostics format: %s (must be text, json or quickfix)", s)
+}
+
+// diagnostic is an error reported by a stage (lexer, parser, analyzer, ...).
+// It has the range of the offending code to show it with the error.
+type diagnostic struct {
+	stage    string    // "lex", "parse", "analyze", ...
+	file     string    // The file name.
+	start    *node.Pos // The start of the offending code (nil-able).
+	end      *node.Pos // The position just after the offending code (nil-able).
+	severity severity  // error or warning.
+	rule     string    // The name of the analyzer rule (maybe empty).
+	message  string    // The error message.
+	notes    []diagnosticNote
+}
+
+// diagnosticNote is an additional message of diagnostic,
+// e.g. the position of the previous declaration.
+type diagnosticNote struct {
+	pos     *node.Pos // The position in the same file (nil-able).
+	message string
+}
+
+// newDiagnostic is the constructor for diagnostic.
+func newDiagnostic(stage, file string, start, end *node.Pos, message string) *diagnostic {
+	return &diagnostic{
+		stage:   stage,
+		file:    file,
+		start:   start,
+		end:     end,
+		message: message,
+	}
+}
+
+// newDiagnosticFromError returns err as a diagnostic at pos.
+// If err is already a diagnostic (e.g. the error of an inner node),
+// it is returned as it is, with pos if it has no position.
+func newDiagnosticFromError(stage, file string, pos *node.Pos, err error) *diagnostic {
+	var d *diagnostic
+	if errors.As(err, &d) {
+		if d.start == nil {
+			d.start = pos
+		}
+		return d
+	}
+	return newDiagnostic(stage, file, pos, nil, err.Error())
+}
+
+// Error returns the one line message "[stage] file:line:col: message".
+func (d *diagnostic) Error() string {
+	var b strings.Builder
+	b.WriteString("[" + d.stage + "] ")
+	b.WriteString(formatFilePos(d.file, d.start))
+	if d.severity != severityError {
+		b.WriteString(d.severity.String() + ": ")
+	}
+	b.WriteString(d.message)
+	if d.rule != "" {
+		b.WriteString(" [" + d.rule + "]")
+	}
+	return b.String()
+}
+
+// render returns the message with the offending line in src,
+// which is underlined by "^~~~", and the notes.
+func (d *diagnostic) render(src string) string {
+	var b strings.Builder
+	b.WriteString(d.Error())
+	b.WriteByte('\n')
+	writeExcerpt(&b, src, d.start, d.end)
+	for _, note := range d.notes {
+		b.WriteString(formatFilePos(d.file, note.pos))
+		b.WriteString("note: " + note.message + "\n")
+		writeExcerpt(&b, src, note.pos, nil)
+	}
+	return b.String()
+}
+
+// diagnosticRecord is the JSON representation of diagnostic.
+// The line and col are 1-origin, and 0 if the position is unknown.
+type diagnosticRecord struct {
+	File     string                 `json:"file"`
+	Line     int                    `json:"line"`
+	Col      int                    `json:"col"`
+	End      *diagnosticRecordPos   `json:"end"`
+	Severity string                 `json:"severity"`
+	Rule     string                 `json:"rule"`
+	Message  string                 `json:"message"`
+	Notes    []diagnosticRecordNote `json:"notes,omitempty"`
+}
+
+type diagnosticRecordPos struct {
+	Line int `json:"line"`
+	Col  int `json:"col"`
+}
+
+type diagnosticRecordNote struct {
+	Line    int    `json:"line"`
+	Col     int    `json:"col"`
+	Message string `json:"message"`
+}
+
+// record returns the JSON representation of d.
+func (d *diagnostic) record() *diagnosticRecord {
+	r := &diagnosticRecord{
+		File:     d.file,
+		Severity: d.severity.String(),
+		Rule:     d.rule,
+		Message:  d.message,
+	}
+	if d.start != nil {
+		r.Line, r.Col = d.start.Line(), d.start.Col()+1
+	}
+	if d.end != nil {
+		r.End = &diagnosticRecordPos{d.end.Line(), d.end.Col() + 1}
+	}
+	for _, note := range d.notes {
+		rn := diagnosticRecordNote{Message: note.message}
+		if note.pos != nil {
+			rn.Line, rn.Col = note.pos.Line(), note.pos.Col()+1
+		}
+		r.Notes = append(r.Notes, rn)
+	}
+	return r
+}
+
+// quickfix returns the lines which Vim's default 'errorformat' can parse:
+// "file:line:col: severity: message", followed by the notes.
+func (d *diagnostic) quickfix() string {
+	if d.file == "" {
+		return d.message + "\n"
+	}
+	var b strings.Builder
+	b.WriteString(formatFilePos(d.file, d.start))
+	b.WriteString(d.severity.String() + ": " + d.message)
+	if d.rule != "" {
+		b.WriteString(" [" + d.rule + "]")
+	}
+	b.WriteByte('\n')
+	for _, note := range d.notes {
+		b.WriteString(formatFilePos(d.file, note.pos))
+		b.WriteString("note: " + note.message + "\n")
+	}
+	return b.String()
+}
+
+// formatFilePos returns "file:line:col: " (or "file: " if pos is nil).
+func formatFilePos(file string, pos *node.Pos) string {
+	if pos == nil {
+		return file + ": "
+	}
+	return fmt.Sprintf("%s:%d:%d: ", file, pos.Line(), pos.Col()+1)
+}
+
+// writeExcerpt writes the line of start in src, and the underline from start
+// to end. If end is nil or is not in the same line, only "^" is written.
+func writeExcerpt(b *strings.Builder, src string, start, end *node.Pos) {
+	if start == nil {
+		return
+	}
+	line := strings.TrimSuffix(getLine(src, start.Line()), "\r")
+	if strings.TrimSpace(line) == "" {
+		return
+	}
+	col := start.Col()
+	if col > len(line) {
+		col = len(line)
+	}
+	width := 1
+	if end != nil && end.Line() == start.Line() && end.Col() > col {
+		e := end.Col()
+		if e > len(line) {
+			e = len(line)
+		}
+		if n := utf8.RuneCountInString(line[col:e]); n > 1 {
+			width = n
+		}
+	}
+	b.WriteString(line + "\n")
+	for _, r := range line[:col] {
+		if r == '\t' {
+			b.WriteByte('\t') // keep the same width as the line
+		} else {
+			b.WriteByte(' ')
+		}
+	}
+	b.WriteString("^" + strings.Repeat("~", width-1) + "\n")
+}
+
+// getLine returns the line of lnum (1-origin) in src without newline.
+// It returns "" if lnum is out of range.
+func getLine(src string, lnum int) string {
+	for ; lnum > 1; lnum-- {
+		i := strings.IndexByte(src, '\n')
+		if i < 0 {
+			return ""
+		}
+		src = src[i+1:]
+	}
+	if i := strings.IndexByte(src, '\n'); i >= 0 {
+		return src[:i]
+	}
+	return src
+}
+
+// isWarning returns true if err is a diagnostic of warning.
+func isWarning(err error) bool {
+	var d *diagnostic
+	return errors.As(err, &d) && d.severity == severityWarning
+}
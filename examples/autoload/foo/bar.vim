@@ -0,0 +1,6 @@
+scriptencoding utf-8
+function! foo#bar#baz() abort
+endfunction
+function! foo#bar#qux() abort
+  42
+endfunction
\ No newline at end of file
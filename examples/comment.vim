@@ -35,7 +35,8 @@ endfunction
 function! s:f(a) abort
   42
 endfunction
-function! s:f(a) abort
+function! s:f(...) abort
+  let a = get(a:, 1, 42)
   42
 endfunction
 function! s:f(a) abort
@@ -15,6 +15,7 @@ function! s:f() abort
   let [_unused3,_unused4,baz] = [1,2,3]
   let [__unused1] = [1]
   let __unused2 = 2
+  let [head; tail] = [1,2,3]
   while 42
     let [l,_unused5] = [123,456]
     let [_unused6,r] = [123,456]
@@ -48,3 +49,63 @@ endfunction
 
 
 
+
+
+
+
+
+function! s:distance2(p) abort
+  return ((p.x * p.x) + (p.y * p.y))
+endfunction
+function! s:new_options(name) abort
+  return {'name':name,'origin':{'x':0,'y':0}}
+endfunction
+function! s:origin_of(opts) abort
+  let name = opts.name
+  let o = opts.origin
+  return o
+endfunction
+function! s:sum_points(points) abort
+  let sum = 0
+  for _dict0 in points
+    let x = _dict0.x
+    let y = _dict0.y
+    let sum += x + y
+  endfor
+  unlet! _dict0
+  return sum
+endfunction
+function! s:echo_items(d) abort
+  for _dict1 in items(d)
+    let key = _dict1[0]
+    let v = _dict1[1]
+    echo((key . ": ") . v)
+  endfor
+  unlet! _dict1
+endfunction
+
+let s:Mode_Normal = 0
+let s:Mode_Insert = 1
+let s:Mode_Visual = 2
+lockvar s:Mode_Normal s:Mode_Insert s:Mode_Visual
+let s:Flag_On = "on"
+let s:Flag_Off = "off"
+lockvar s:Flag_On s:Flag_Off
+function! s:mode_name(m) abort
+  if m ==# s:Mode_Normal
+    return "normal"
+  elseif m ==# s:Mode_Insert
+    return "insert"
+  elseif m ==# s:Mode_Visual
+    return "visual"
+  endif
+  return ""
+endfunction
+function! s:is_on(f) abort
+  if type(f) == v:t_string && f ==# s:Flag_On
+    return v:true
+  elseif type(f) == v:t_string && f ==# s:Flag_Off
+    return v:false
+  endif
+  return v:false
+endfunction
\ No newline at end of file
@@ -14,6 +14,21 @@ scriptencoding utf-8
 
 
 
+
+
+
+
+
+
+
+
+
+
+
+
+
+
+
 
 
 
@@ -0,0 +1,15 @@
+scriptencoding utf-8
+nnoremap <silent> <Plug>(vain-hello) :<C-u>echo 'hello'<CR>
+augroup vain
+  autocmd!
+augroup END
+let lhs = "<Leader>h"
+execute "nmap " . lhs . " <Plug>(vain-hello)"
+execute 'echo ' . fnameescape(len([1,2])) . ' "items"'
+function! s:setup(count) abort
+  if exists(':Foo')
+    delcommand Foo
+  endif
+  execute "normal! " . fnameescape(count) . "j"
+  execute "echo " . count
+endfunction
\ No newline at end of file
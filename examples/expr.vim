@@ -48,7 +48,8 @@ foo.from
 let bar = ''
 foo[bar]
 foo["bar"]
-call foo()
+let fn = {->42}
+call fn()
 call foo.bar()
 call foo.from()
 call foo[bar]()
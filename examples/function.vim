@@ -48,6 +48,10 @@ function! s:_vain_dummy_lambda5(a) abort
 endfunction
 function! s:_vain_dummy_lambda6(a) abort
 endfunction
+function! s:_vain_dummy_lambda7(a,...) abort
+  let b = get(a:, 1, 1)
+  return a + b
+endfunction
 " vain: end named expression functions
 
 function! s:f1() abort
@@ -119,4 +123,23 @@ function('s:_vain_dummy_lambda5')
 {a->42}
 function('s:_vain_dummy_lambda6')
 function! s:func_with_type() abort
-endfunction
\ No newline at end of file
+endfunction
+function! s:f14(a,...) abort
+  let rest = a:000
+endfunction
+{a,...->a:000}
+{a,b->a + b}
+{a->a * 2}
+{->42}
+function('s:_vain_dummy_lambda7')
+function! s:f15(a,...) abort
+  let b = get(a:, 1, 42)
+  let c = get(a:, 2, "x")
+  let rest = a:000[2:]
+endfunction
+function! s:f16(xs,d,cb) abort
+endfunction
+function! s:f17(v,cb) abort
+  return []
+endfunction
+{xs->xs}
\ No newline at end of file
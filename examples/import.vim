@@ -2,3 +2,14 @@ scriptencoding utf-8
 
 
 
+let s:exe = function('execute')
+
+let s:baz = function('foo#bar#baz')
+let s:quux = function('foo#bar#qux')
+call echo('hello')
+call echo('hello')
+call echo('hello')
+call s:exe('echo "hello"')
+call foo#bar#baz()
+call s:baz()
+call s:quux()
\ No newline at end of file
@@ -1,5 +1,9 @@
 scriptencoding utf-8
 42
+v:true
+v:false
+v:null
+v:null
 "string"
 'str''ing'
 "hel'lo"
@@ -0,0 +1,27 @@
+scriptencoding utf-8
+function! s:hello() abort
+  call echo("hello")
+endfunction
+function! s:greet(name) abort
+  call echo("hello, " . name)
+endfunction
+function! s:open(...) abort
+  let files = a:000
+  for f in files
+    execute "edit " . fnameescape(f)
+  endfor
+endfunction
+function! s:setup() abort
+  call echo("setup")
+endfunction
+nnoremap <silent> <Leader>h :<C-u>call <SID>hello()<CR>
+xnoremap <silent> <Leader>h :<C-u>call <SID>hello()<CR>
+inoremap <buffer> <nowait> <C-g>h <C-o>:call <SID>hello()<CR>
+command! Hello call s:hello()
+command! -nargs=1 Greet call s:greet(<f-args>)
+command! -nargs=* -complete=file -bar Open call s:open(<f-args>)
+augroup vain
+  autocmd!
+  autocmd BufRead,BufNewFile *.vain call s:setup()
+  autocmd FileType vain nested call s:setup()
+augroup END
\ No newline at end of file
@@ -22,18 +22,64 @@ while 42
   echo("what's up")
 endwhile
 for v in [1,2,3]
-  echo("hey:" + v)
+  echo("hey:" . v)
   echo("yo")
 endfor
 let range = function('s:_vain_dummy_lambda2')
 for n in range(1,100)
-  if (n % 15) ==# 0
+  let _match0 = [n % 3,n % 5]
+  if type(_match0) == v:t_list && len(_match0) == 2 && type(_match0[0]) == v:t_number && _match0[0] == 0 && type(_match0[1]) == v:t_number && _match0[1] == 0
     echo("fizzbuzz")
-  elseif (n % 5) ==# 0
-    echo("buzz")
-  elseif (n % 3) ==# 0
+  elseif type(_match0) == v:t_list && len(_match0) == 2 && type(_match0[0]) == v:t_number && _match0[0] == 0
     echo("fizz")
+  elseif type(_match0) == v:t_list && len(_match0) == 2 && type(_match0[1]) == v:t_number && _match0[1] == 0
+    echo("buzz")
   else
     echo(n.toString())
   endif
+  unlet _match0
+endfor
+let _match1 = ["vain",1]
+if type(_match1) == v:t_list && len(_match1) == 2 && type(_match1[0]) == v:t_string && _match1[0] ==# "vain"
+  let ver = _match1[1]
+  echo("vain")
+  echo(ver)
+elseif type(_match1) == v:t_list && len(_match1) == 2
+  let name = _match1[0]
+  echo(name)
+endif
+unlet _match1
+let plugin = {"name":"vain","tags":["vim","compiler"]}
+if type(plugin) == v:t_dict && has_key(plugin, 'name') && type(plugin.name) == v:t_string && plugin.name ==# "vain" && has_key(plugin, 'tags') && type(plugin.tags) == v:t_list && len(plugin.tags) >= 1
+  let first = plugin.tags[0]
+  let rest = plugin.tags[1:]
+  echo(first)
+elseif type(plugin) == v:t_dict && has_key(plugin, 'name')
+  let name = plugin.name
+  echo(name)
+endif
+try
+  throw "vain: error"
+catch /^vain:/
+  let e = v:exception
+  echo(e)
+catch
+  echo("unknown error")
+finally
+  echo("done")
+endtry
+for i in range(1,100)
+  if (i % 2) ==# 0
+    continue
+  endif
+  while i ># 50
+    break
+  endwhile
+endfor
+let sum = 0
+let msg = "sum:"
+for j in range(1,10)
+  let sum += j
+  let sum *= 2
+  let msg .= " "
 endfor
\ No newline at end of file
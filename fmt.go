@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"reflect"
@@ -26,8 +27,14 @@ type formatter struct {
 	level      int
 }
 
+// Run formats the nodes from the parser.
+// The partial top-level node is not formatted because
+// the syntax errors were sent before it (see parser.Run()).
 func (f *formatter) Run() {
 	for node := range f.inNodes {
+		if top, ok := node.TerminalNode().(*topLevelNode); ok && top.partial {
+			continue
+		}
 		f.emit(f.toReader(node, nil))
 	}
 	close(f.outReaders)
@@ -42,14 +49,7 @@ func (f *formatter) emit(r io.Reader) {
 }
 
 func (f *formatter) err(err error, n node.Node) io.Reader {
-	if pos := n.Position(); pos != nil {
-		return &errorReader{
-			fmt.Errorf("[fmt] %s:%d:%d: "+err.Error(), f.name, pos.Line(), pos.Col()+1),
-		}
-	}
-	return &errorReader{
-		fmt.Errorf("[fmt] %s: "+err.Error(), f.name),
-	}
+	return &errorReader{newDiagnosticFromError("fmt", f.name, n.Position(), err)}
 }
 
 func (f *formatter) incIndent() {
@@ -87,12 +87,42 @@ func (f *formatter) toReader(node, parent node.Node) io.Reader {
 		return f.newAssignStatementReader(n, parent, "let")
 	case *assignExpr:
 		return f.newAssignStatementReader(n, parent, "")
+	case *compoundAssignExpr:
+		return f.newAssignStatementReader(n, parent, "")
 	case *ifStatement:
 		return f.newIfStatementReader(n, parent, true)
 	case *whileStatement:
 		return f.newWhileStatementReader(n, parent)
 	case *forStatement:
 		return f.newForStatementReader(n, parent)
+	case *tryStatement:
+		return f.newTryStatementReader(n, parent)
+	case *matchStatement:
+		return f.newMatchStatementReader(n, parent)
+	case *throwStatement:
+		return f.newThrowStatementReader(n, parent)
+	case *breakStatement:
+		return strings.NewReader("break")
+	case *vimBlockStatement:
+		return f.newVimBlockStatementReader(n, parent)
+	case *executeStatement:
+		return f.newExecuteStatementReader(n, parent)
+	case *templateNode:
+		return f.newTemplateNodeReader(n, parent)
+	case *mapStatement:
+		return f.newMapStatementReader(n, parent)
+	case *commandStatement:
+		return f.newCommandStatementReader(n, parent)
+	case *autocmdStatement:
+		return f.newAutocmdStatementReader(n, parent)
+	case *augroupStatement:
+		return f.newAugroupStatementReader(n, parent)
+	case *typeDeclareStatement:
+		return f.newTypeDeclareStatementReader(n, parent)
+	case *enumStatement:
+		return f.newEnumStatementReader(n, parent)
+	case *continueStatement:
+		return strings.NewReader("continue")
 	case *ternaryNode:
 		return f.newTernaryNodeReader(n, parent)
 	case *orNode:
@@ -143,6 +173,8 @@ func (f *formatter) toReader(node, parent node.Node) io.Reader {
 		return f.newBinaryOpNodeReader(n, parent, "+")
 	case *subtractNode:
 		return f.newBinaryOpNodeReader(n, parent, "-")
+	case *concatNode:
+		return f.newBinaryOpNodeReader(n, parent, "..")
 	case *multiplyNode:
 		return f.newBinaryOpNodeReader(n, parent, "*")
 	case *divideNode:
@@ -169,6 +201,10 @@ func (f *formatter) toReader(node, parent node.Node) io.Reader {
 		return f.newIntNodeReader(n, parent)
 	case *floatNode:
 		return f.newFloatNodeReader(n, parent)
+	case *boolNode:
+		return f.newBoolNodeReader(n, parent)
+	case *noneNode:
+		return f.newNoneNodeReader(n, parent)
 	case *stringNode:
 		return f.newStringNodeReader(n, parent)
 	case *listNode:
@@ -197,7 +233,7 @@ func (f *formatter) newTopLevelNodeReader(node *topLevelNode) io.Reader {
 		buf.WriteString(f.indent())
 		_, err := io.Copy(&buf, f.toReader(node.body[i], node))
 		if err != nil {
-			return f.err(err, node)
+			return f.err(err, node.body[i])
 		}
 	}
 	return strings.NewReader(buf.String())
@@ -282,14 +318,17 @@ func (f *formatter) newFuncDeclareStatementReader(n *funcDeclareStatement, paren
 		}
 	}
 	buf.WriteString(")")
-	if n.retType != "" {
+	if n.retType != nil {
 		buf.WriteString(": ")
-		buf.WriteString(n.retType)
+		buf.WriteString(n.retType.String())
 	}
 	return strings.NewReader(buf.String())
 }
 
 func (f *formatter) newFuncReader(n *funcStmtOrExpr, parent node.Node) io.Reader {
+	if n.isArrow {
+		return f.newArrowLambdaReader(n, parent)
+	}
 	var buf bytes.Buffer
 	declare := f.newFuncDeclareStatementReader(n.declare, parent)
 	_, err := io.Copy(&buf, declare)
@@ -327,6 +366,34 @@ func (f *formatter) newFuncReader(n *funcStmtOrExpr, parent node.Node) io.Reader
 	return strings.NewReader(buf.String())
 }
 
+func (f *formatter) newArrowLambdaReader(n *funcStmtOrExpr, parent node.Node) io.Reader {
+	var buf bytes.Buffer
+	buf.WriteString("(")
+	for i := range n.declare.args {
+		if i > 0 {
+			buf.WriteString(", ")
+		}
+		arg := &n.declare.args[i]
+		if arg.typ == nil && arg.defaultVal == nil {
+			_, err := io.Copy(&buf, f.toReader(arg.left, n))
+			if err != nil {
+				return f.err(err, arg.left)
+			}
+			continue
+		}
+		_, err := io.Copy(&buf, f.newArgumentReader(arg, n))
+		if err != nil {
+			return f.err(err, arg.left)
+		}
+	}
+	buf.WriteString(") -> ")
+	_, err := io.Copy(&buf, f.toReader(n.body[0], n))
+	if err != nil {
+		return f.err(err, n.body[0])
+	}
+	return strings.NewReader(buf.String())
+}
+
 func (f *formatter) newArgumentReader(n *argument, parent node.Node) io.Reader {
 	var buf bytes.Buffer
 	// TODO change argument.left to *identifierNode
@@ -342,14 +409,18 @@ func (f *formatter) newArgumentReader(n *argument, parent node.Node) io.Reader {
 			reflect.TypeOf(n.left),
 		), n.left)
 	}
-	buf.WriteString(": ")
 	if n.defaultVal != nil {
+		buf.WriteString(" = ")
 		_, err := io.Copy(&buf, f.toReader(n.defaultVal, parent))
 		if err != nil {
 			return f.err(err, n.defaultVal)
 		}
-	} else if n.typ != "" {
-		buf.WriteString(n.typ)
+	} else if n.typ != nil {
+		buf.WriteString(": ")
+		if n.variadic {
+			buf.WriteString("...")
+		}
+		buf.WriteString(n.typ.String())
 	} else {
 		return f.err(fmt.Errorf(
 			"fatal: unexpected node: both argument.typ and n.defaultVal must not be empty (%+v)",
@@ -435,7 +506,7 @@ func (f *formatter) newWhileStatementReader(node *whileStatement, parent node.No
 func (f *formatter) newForStatementReader(node *forStatement, parent node.Node) io.Reader {
 	var buf bytes.Buffer
 	buf.WriteString("for ")
-	_, err := io.Copy(&buf, f.toReader(node.left, parent))
+	_, err := io.Copy(&buf, f.newPatternReader(node.left, parent))
 	if err != nil {
 		return f.err(err, node.left)
 	}
@@ -460,6 +531,233 @@ func (f *formatter) newForStatementReader(node *forStatement, parent node.Node)
 	return strings.NewReader(buf.String())
 }
 
+func (f *formatter) newTryStatementReader(n *tryStatement, parent node.Node) io.Reader {
+	var buf bytes.Buffer
+	writeBlock := func(body []node.Node) io.Reader {
+		buf.WriteString(" {\n")
+		f.incIndent()
+		for i := range body {
+			buf.WriteString(f.indent())
+			_, err := io.Copy(&buf, f.toReader(body[i], n))
+			if err != nil {
+				f.decIndent()
+				return f.err(err, body[i])
+			}
+			buf.WriteString("\n")
+		}
+		f.decIndent()
+		buf.WriteString(f.indent())
+		buf.WriteString("}")
+		return nil
+	}
+	buf.WriteString("try")
+	if r := writeBlock(n.body); r != nil {
+		return r
+	}
+	for i := range n.catches {
+		buf.WriteString(" catch")
+		if n.catches[i].pattern != "" {
+			buf.WriteString(" ")
+			buf.WriteString(n.catches[i].pattern)
+		}
+		if n.catches[i].left != nil {
+			buf.WriteString(" ")
+			_, err := io.Copy(&buf, f.toReader(n.catches[i].left, n))
+			if err != nil {
+				return f.err(err, n.catches[i].left)
+			}
+		}
+		if r := writeBlock(n.catches[i].body); r != nil {
+			return r
+		}
+	}
+	if n.hasFinally {
+		buf.WriteString(" finally")
+		if r := writeBlock(n.finally); r != nil {
+			return r
+		}
+	}
+	return strings.NewReader(buf.String())
+}
+
+func (f *formatter) newThrowStatementReader(n *throwStatement, parent node.Node) io.Reader {
+	var buf bytes.Buffer
+	buf.WriteString("throw ")
+	_, err := io.Copy(&buf, f.paren(f.toReader(n.left, parent), n.left))
+	if err != nil {
+		return f.err(err, n.left)
+	}
+	return strings.NewReader(buf.String())
+}
+
+func (f *formatter) newVimBlockStatementReader(node *vimBlockStatement, parent node.Node) io.Reader {
+	var buf bytes.Buffer
+	buf.WriteString("vim {\n")
+	f.incIndent()
+	for i := range node.lines {
+		if node.lines[i] != "" {
+			buf.WriteString(f.indent())
+			buf.WriteString(node.lines[i])
+		}
+		buf.WriteString("\n")
+	}
+	f.decIndent()
+	buf.WriteString(f.indent())
+	buf.WriteString("}")
+	return strings.NewReader(buf.String())
+}
+
+func (f *formatter) newExecuteStatementReader(n *executeStatement, parent node.Node) io.Reader {
+	var buf bytes.Buffer
+	buf.WriteString("execute ")
+	_, err := io.Copy(&buf, f.toReader(n.left, n))
+	if err != nil {
+		return f.err(err, n.left)
+	}
+	return strings.NewReader(buf.String())
+}
+
+// formatExOptions formats the options of Ex command DSL.
+// Returned string has a leading space if it is not empty.
+func formatExOptions(opts []exOption) string {
+	var buf bytes.Buffer
+	for i := range opts {
+		buf.WriteString(" -" + opts[i].name)
+		if opts[i].value != "" {
+			buf.WriteString("=" + opts[i].value)
+		}
+	}
+	return buf.String()
+}
+
+func (f *formatter) newMapStatementReader(node *mapStatement, parent node.Node) io.Reader {
+	var buf bytes.Buffer
+	buf.WriteString("map " + node.modes)
+	buf.WriteString(formatExOptions(node.options))
+	buf.WriteString(" " + string(node.lhs) + " ")
+	_, err := io.Copy(&buf, f.toReader(node.callback, node))
+	if err != nil {
+		return f.err(err, node.callback)
+	}
+	return strings.NewReader(buf.String())
+}
+
+func (f *formatter) newCommandStatementReader(node *commandStatement, parent node.Node) io.Reader {
+	var buf bytes.Buffer
+	buf.WriteString("command")
+	buf.WriteString(formatExOptions(node.options))
+	buf.WriteString(" " + node.name + " ")
+	_, err := io.Copy(&buf, f.toReader(node.callback, node))
+	if err != nil {
+		return f.err(err, node.callback)
+	}
+	return strings.NewReader(buf.String())
+}
+
+func (f *formatter) newAutocmdStatementReader(node *autocmdStatement, parent node.Node) io.Reader {
+	var buf bytes.Buffer
+	buf.WriteString("autocmd")
+	buf.WriteString(formatExOptions(node.options))
+	buf.WriteString(" " + strings.Join(node.events, ","))
+	buf.WriteString(" " + string(node.pattern) + " ")
+	_, err := io.Copy(&buf, f.toReader(node.callback, node))
+	if err != nil {
+		return f.err(err, node.callback)
+	}
+	return strings.NewReader(buf.String())
+}
+
+func (f *formatter) newAugroupStatementReader(node *augroupStatement, parent node.Node) io.Reader {
+	var buf bytes.Buffer
+	buf.WriteString("augroup " + node.name + " {\n")
+	f.incIndent()
+	for i := range node.body {
+		buf.WriteString(f.indent())
+		_, err := io.Copy(&buf, f.toReader(node.body[i], node))
+		if err != nil {
+			f.decIndent()
+			return f.err(err, node.body[i])
+		}
+		buf.WriteString("\n")
+	}
+	f.decIndent()
+	buf.WriteString(f.indent())
+	buf.WriteString("}")
+	return strings.NewReader(buf.String())
+}
+
+// newMatchStatementReader prints one arm per line.
+// The body of an arm is printed as a block unless it is a single statement.
+func (f *formatter) newMatchStatementReader(n *matchStatement, parent node.Node) io.Reader {
+	var buf bytes.Buffer
+	writeArm := func(arm *matchArm) io.Reader {
+		buf.WriteString(f.indent())
+		_, err := io.Copy(&buf, f.newPatternReader(arm.pattern, n))
+		if err != nil {
+			return f.err(err, arm.pattern)
+		}
+		buf.WriteString(" -> ")
+		switch len(arm.body) {
+		case 0:
+			buf.WriteString("{}")
+		case 1:
+			_, err := io.Copy(&buf, f.toReader(arm.body[0], n))
+			if err != nil {
+				return f.err(err, arm.body[0])
+			}
+		default:
+			buf.WriteString("{\n")
+			f.incIndent()
+			for i := range arm.body {
+				buf.WriteString(f.indent())
+				_, err := io.Copy(&buf, f.toReader(arm.body[i], n))
+				if err != nil {
+					f.decIndent()
+					return f.err(err, arm.body[i])
+				}
+				buf.WriteString("\n")
+			}
+			f.decIndent()
+			buf.WriteString(f.indent())
+			buf.WriteString("}")
+		}
+		buf.WriteString("\n")
+		return nil
+	}
+	buf.WriteString("match ")
+	_, err := io.Copy(&buf, f.toReader(n.left, n))
+	if err != nil {
+		return f.err(err, n.left)
+	}
+	buf.WriteString(" {\n")
+	f.incIndent()
+	for i := range n.arms {
+		if r := writeArm(&n.arms[i]); r != nil {
+			f.decIndent()
+			return r
+		}
+	}
+	f.decIndent()
+	buf.WriteString(f.indent())
+	buf.WriteString("}")
+	return strings.NewReader(buf.String())
+}
+
+func (f *formatter) newTypeDeclareStatementReader(node *typeDeclareStatement, parent node.Node) io.Reader {
+	return strings.NewReader("type " + node.typ.name + " = " + node.typ.fieldsString())
+}
+
+func (f *formatter) newEnumStatementReader(node *enumStatement, parent node.Node) io.Reader {
+	variants := make([]string, len(node.typ.variants))
+	for i, v := range node.typ.variants {
+		variants[i] = v.name
+		if v.explicit {
+			variants[i] += " = " + v.value
+		}
+	}
+	return strings.NewReader("enum " + node.typ.name + " {" + strings.Join(variants, ", ") + "}")
+}
+
 func (f *formatter) newReturnNodeReader(n *returnStatement, parent node.Node) io.Reader {
 	if n.left == nil {
 		return strings.NewReader("return")
@@ -479,11 +777,15 @@ func (f *formatter) newAssignStatementReader(node assignNode, parent node.Node,
 		buf.WriteString(opstr)
 		buf.WriteString(" ")
 	}
-	_, err := io.Copy(&buf, f.toReader(node.Left(), parent))
+	_, err := io.Copy(&buf, f.newPatternReader(node.Left(), parent))
 	if err != nil {
 		return f.err(err, node.Left())
 	}
-	buf.WriteString(" = ")
+	if c, ok := node.(*compoundAssignExpr); ok {
+		buf.WriteString(" " + c.op + " ")
+	} else {
+		buf.WriteString(" = ")
+	}
 	_, err = io.Copy(&buf, f.toReader(node.Right(), parent))
 	if err != nil {
 		return f.err(err, node.Right())
@@ -491,6 +793,48 @@ func (f *formatter) newAssignStatementReader(node assignNode, parent node.Node,
 	return strings.NewReader(buf.String())
 }
 
+// newPatternReader prints the pattern of destructuring assignment or match arm.
+// Dictionary patterns are printed as "{name, path: p}"
+// because the keys are not string literals.
+func (f *formatter) newPatternReader(pattern, parent node.Node) io.Reader {
+	switch p := pattern.TerminalNode().(type) {
+	case *listNode:
+		elems := make([]string, 0, len(p.value))
+		for i := range p.value {
+			var elem bytes.Buffer
+			_, err := io.Copy(&elem, f.newPatternReader(p.value[i], parent))
+			if err != nil {
+				return f.err(err, p.value[i])
+			}
+			elems = append(elems, elem.String())
+		}
+		if p.rest {
+			elems[len(elems)-1] = "..." + elems[len(elems)-1]
+		}
+		return strings.NewReader("[" + strings.Join(elems, ",") + "]")
+	case *dictionaryNode:
+		entries := make([]string, 0, len(p.value))
+		for i := range p.value {
+			key, ok := p.value[i][0].TerminalNode().(*identifierNode)
+			if !ok {
+				return f.err(errors.New("fatal: the key of dictionary pattern must be identifier"), pattern)
+			}
+			if value, ok := p.value[i][1].TerminalNode().(*identifierNode); ok && key.value == value.value {
+				entries = append(entries, key.value)
+				continue
+			}
+			var value bytes.Buffer
+			_, err := io.Copy(&value, f.newPatternReader(p.value[i][1], parent))
+			if err != nil {
+				return f.err(err, p.value[i][1])
+			}
+			entries = append(entries, key.value+": "+value.String())
+		}
+		return strings.NewReader("{" + strings.Join(entries, ", ") + "}")
+	}
+	return f.toReader(pattern, parent)
+}
+
 func (f *formatter) newLetDeclareStatementReader(n *letDeclareStatement, parent node.Node) io.Reader {
 	var buf bytes.Buffer
 	buf.WriteString("let ")
@@ -648,10 +992,43 @@ func (f *formatter) newFloatNodeReader(node *floatNode, parent node.Node) io.Rea
 	return strings.NewReader(node.value)
 }
 
+func (f *formatter) newBoolNodeReader(node *boolNode, parent node.Node) io.Reader {
+	if node.value {
+		return strings.NewReader("true")
+	}
+	return strings.NewReader("false")
+}
+
+func (f *formatter) newNoneNodeReader(node *noneNode, parent node.Node) io.Reader {
+	return strings.NewReader("none")
+}
+
 func (f *formatter) newStringNodeReader(node *stringNode, parent node.Node) io.Reader {
 	return strings.NewReader(string(node.value))
 }
 
+func (f *formatter) newTemplateNodeReader(node *templateNode, parent node.Node) io.Reader {
+	var buf bytes.Buffer
+	buf.WriteString(node.quote)
+	for i := range node.lits {
+		buf.WriteString(node.lits[i])
+		if i < len(node.exprs) {
+			if node.raw[i] {
+				buf.WriteString("$!{")
+			} else {
+				buf.WriteString("${")
+			}
+			_, err := io.Copy(&buf, f.toReader(node.exprs[i], node))
+			if err != nil {
+				return f.err(err, node.exprs[i])
+			}
+			buf.WriteString("}")
+		}
+	}
+	buf.WriteString(node.quote)
+	return strings.NewReader(buf.String())
+}
+
 func (f *formatter) newLiteralNodeReader(node literalNode, parent node.Node, opstr string) io.Reader {
 	return strings.NewReader(opstr + node.Value())
 }
@@ -666,6 +1043,9 @@ func (f *formatter) newListNodeReader(node *listNode, parent node.Node) io.Reade
 		}
 		args = append(args, arg.String())
 	}
+	if node.rest {
+		args[len(args)-1] = "..." + args[len(args)-1]
+	}
 	s := "[" + strings.Join(args, ",") + "]"
 	return strings.NewReader(s)
 }
@@ -723,6 +1103,34 @@ func (f *formatter) needsParen(node node.Node) bool {
 		return false
 	case *returnStatement:
 		return false
+	case *tryStatement:
+		return false
+	case *matchStatement:
+		return false
+	case *throwStatement:
+		return false
+	case *breakStatement:
+		return false
+	case *vimBlockStatement:
+		return false
+	case *executeStatement:
+		return false
+	case *templateNode:
+		return false
+	case *mapStatement:
+		return false
+	case *commandStatement:
+		return false
+	case *autocmdStatement:
+		return false
+	case *augroupStatement:
+		return false
+	case *typeDeclareStatement:
+		return false
+	case *enumStatement:
+		return false
+	case *continueStatement:
+		return false
 	case *ternaryNode:
 		return true
 	case *orNode:
@@ -773,6 +1181,8 @@ func (f *formatter) needsParen(node node.Node) bool {
 		return true
 	case *subtractNode:
 		return true
+	case *concatNode:
+		return true
 	case *multiplyNode:
 		return true
 	case *divideNode:
@@ -799,6 +1209,10 @@ func (f *formatter) needsParen(node node.Node) bool {
 		return false
 	case *floatNode:
 		return false
+	case *boolNode:
+		return false
+	case *noneNode:
+		return false
 	case *stringNode:
 		return false
 	case *listNode:
@@ -0,0 +1,1196 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/tyru/vain/node"
+)
+
+// Type names of expressions.
+// typeUnknown means the type could not be inferred,
+// and it is compatible with any other types.
+const (
+	typeUnknown = ""
+	typeInt     = "Int"
+	typeFloat   = "Float"
+	typeString  = "String"
+	typeBool    = "Bool"
+	typeNone    = "None"
+	typeList    = "List"
+	typeDict    = "Dict"
+	typeFunc    = "Func"
+	typeVoid    = "Void"
+	// typeAny is used by function declarations (e.g. standard library)
+	// to accept the value of any type.
+	typeAny = "Any"
+)
+
+// infer infers each node's type and return the tree of *typedNode.
+func (a *analyzer) infer(top node.Node) (*typedNode, []node.ErrorNode) {
+	typedTop := walkNode(top, func(_ *walkCtrl, n node.Node) node.Node {
+		return &typedNode{n.Clone(), typeUnknown, nil}
+	}).(*typedNode) // returned node must be *topLevelNode
+	errs := a.inferNode(typedTop, NewScope(), nil)
+	return typedTop, errs
+}
+
+// typeOf returns the inferred type of n.
+// If n is not *typedNode, returns typeUnknown.
+func typeOf(n node.Node) string {
+	if tn, ok := n.(*typedNode); ok {
+		return tn.typ
+	}
+	return typeUnknown
+}
+
+// typeExprOf returns the type expression of n.
+// It has the element types of List and Dict if they are known
+// (e.g. "List<Int>" for "[1, 2]").
+// If the type of n is unknown, returns nil.
+func typeExprOf(n node.Node) typeExpr {
+	tn, ok := n.(*typedNode)
+	switch {
+	case !ok:
+		return nil
+	case tn.decl != nil:
+		return tn.decl
+	case tn.typ == typeUnknown:
+		return nil
+	}
+	return &namedType{tn.typ}
+}
+
+// elementType returns the type of the elements of List or Dict literal.
+// If the elements have different types, returns the union of them.
+// If there is no element or the type of an element is unknown, returns nil.
+func elementType(values []expr) typeExpr {
+	types := make([]typeExpr, 0, 2)
+	seen := make(map[string]bool, 2)
+	for i := range values {
+		t := typeExprOf(values[i])
+		if t == nil {
+			return nil
+		}
+		if !seen[t.String()] {
+			seen[t.String()] = true
+			types = append(types, t)
+		}
+	}
+	switch len(types) {
+	case 0:
+		return nil
+	case 1:
+		return types[0]
+	}
+	return &unionType{types}
+}
+
+// isBuiltinType returns true if name is the name of builtin type.
+func isBuiltinType(name string) bool {
+	switch name {
+	case typeInt, typeFloat, typeString, typeBool, typeNone,
+		typeList, typeDict, typeFunc, typeVoid, typeAny:
+		return true
+	}
+	return false
+}
+
+// declaredType converts the type expression of declaration to the type of expression.
+// Type arguments are dropped (e.g. "List<Int>" is "List"),
+// use typeExprOf() and isAssignableType() to check them.
+// The names of declared types must be resolved by Scope.resolveType() before.
+func declaredType(t typeExpr) string {
+	switch tt := t.(type) {
+	case *namedType:
+		if tt.name == typeAny || !isBuiltinType(tt.name) {
+			return typeUnknown
+		}
+		return tt.name
+	case *listType:
+		return typeList
+	case *dictType, *recordType:
+		return typeDict
+	case *enumType:
+		return tt.valueType()
+	case *funcType:
+		return typeFunc
+	case *optionalType:
+		// None is assignable to typ, so "typ?" is the same as typ.
+		if typ := declaredType(tt.elem); isAssignable(typ, typeNone) {
+			return typ
+		}
+	case *unionType:
+		typ := declaredType(tt.types[0])
+		for i := range tt.types[1:] {
+			if declaredType(tt.types[i+1]) != typ {
+				return typeUnknown
+			}
+		}
+		return typ
+	}
+	return typeUnknown
+}
+
+// isAssignableType returns true if the value of type from
+// can be assigned to the variable declared as type to.
+// If to is nil (not specified) or from is nil (unknown), it returns true.
+// The element types of List and Dict are also checked.
+func isAssignableType(to, from typeExpr) bool {
+	if to == nil || from == nil {
+		return true
+	}
+	if ft, ok := from.(*unionType); ok {
+		// Each type of the union must be assignable.
+		for i := range ft.types {
+			if !isAssignableType(to, ft.types[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	switch tt := to.(type) {
+	case *optionalType:
+		return declaredType(from) == typeNone || isAssignableType(tt.elem, from)
+	case *unionType:
+		for i := range tt.types {
+			if isAssignableType(tt.types[i], from) {
+				return true
+			}
+		}
+		return false
+	case *listType:
+		if ft, ok := from.(*listType); ok {
+			return isAssignableType(tt.elem, ft.elem)
+		}
+	case *dictType:
+		if ft, ok := from.(*dictType); ok {
+			return isAssignableType(tt.value, ft.value)
+		}
+	}
+	return isAssignable(declaredType(to), declaredType(from))
+}
+
+// funcTypeOf returns the type of the function declared by f.
+// The type of arguments without type is Any.
+func funcTypeOf(f *funcDeclareStatement) *funcType {
+	args := make([]typeExpr, len(f.args))
+	for i := range f.args {
+		switch {
+		case f.args[i].variadic:
+			args[i] = &listType{f.args[i].typ}
+		case f.args[i].typ != nil:
+			args[i] = f.args[i].typ
+		default:
+			args[i] = &namedType{typeAny}
+		}
+	}
+	return &funcType{args, f.retType}
+}
+
+// checkFuncValue checks if the function f can be used as the value of type t.
+// The function must accept the number of arguments of t,
+// and its return type must be assignable to the one of t.
+func checkFuncValue(t *funcType, f *funcDeclareStatement) bool {
+	min, max, variadic := funcArity(f)
+	if len(t.args) < min || (!variadic && len(t.args) > max) {
+		return false
+	}
+	if t.ret == nil || f.retType == nil {
+		return true
+	}
+	return isAssignableType(t.ret, f.retType)
+}
+
+func isNumericType(typ string) bool {
+	return typ == typeInt || typ == typeFloat
+}
+
+// isAssignable returns true if the value of type from
+// can be assigned to the variable of type to.
+func isAssignable(to, from string) bool {
+	switch {
+	case to == typeUnknown || from == typeUnknown || to == typeAny:
+		return true
+	case to == from:
+		return true
+	case to == typeFloat && from == typeInt:
+		return true
+	case from == typeNone:
+		return !isNumericType(to) && to != typeBool
+	}
+	return false
+}
+
+// inferBody infers the types of nodes in a new scope.
+func (a *analyzer) inferBody(body []node.Node, scope *Scope, fn *funcDeclareStatement) []node.ErrorNode {
+	errs := make([]node.ErrorNode, 0, 4)
+	scope.push()
+	// Functions and types can be used before the definition.
+	for i := range body {
+		switch n := body[i].TerminalNode().(type) {
+		case *funcStmtOrExpr:
+			if !n.isExpr && n.declare.name != "" {
+				scope.setFunc(n.declare.name, n.declare)
+			}
+		case *typeDeclareStatement:
+			if scope.getTypeDef(n.typ.name) != nil {
+				errs = append(errs, *a.err(fmt.Errorf("duplicate type: %s", n.typ.name), body[i]))
+				continue
+			}
+			scope.setTypeDef(n.typ.name, n.typ)
+		case *enumStatement:
+			if scope.getTypeDef(n.typ.name) != nil {
+				errs = append(errs, *a.err(fmt.Errorf("duplicate type: %s", n.typ.name), body[i]))
+				continue
+			}
+			scope.setTypeDef(n.typ.name, n.typ)
+		}
+	}
+	for i := range body {
+		errs = append(errs, a.inferNode(body[i], scope, fn)...)
+	}
+	scope.pop()
+	return errs
+}
+
+// inferNode sets the type of n and its inner nodes.
+// fn is the function which n belongs to (nil at top level).
+func (a *analyzer) inferNode(n node.Node, scope *Scope, fn *funcDeclareStatement) []node.ErrorNode {
+	tn, ok := n.(*typedNode)
+	if !ok {
+		return nil
+	}
+	errs := make([]node.ErrorNode, 0, 4)
+	addErr := func(err error) {
+		errs = append(errs, *a.err(err, n))
+	}
+	infer := func(nodes ...node.Node) {
+		for i := range nodes {
+			errs = append(errs, a.inferNode(nodes[i], scope, fn)...)
+		}
+	}
+
+	switch nn := tn.TerminalNode().(type) {
+	case *topLevelNode:
+		errs = append(errs, a.inferBody(nn.body, scope, fn)...)
+	case *importStatement:
+		path, _ := nn.pkg.eval()
+		if len(nn.fnlist) == 0 {
+			scope.setPackage(nn.pkgName(), path)
+			break
+		}
+		for _, pair := range nn.importedNames() {
+			if f, loaded := a.getImportedFunc(path, pair[0]); f != nil {
+				scope.setFunc(pair[1], f)
+			} else if loaded {
+				addErr(fmt.Errorf("cannot import %s: not declared in '%s'", pair[0], path))
+				scope.setType(pair[1], typeUnknown)
+			} else {
+				scope.setType(pair[1], typeFunc)
+			}
+		}
+	case *funcDeclareStatement:
+		if nn.name != "" {
+			scope.setFunc(nn.name, nn)
+		}
+		if err := checkFuncTypes(nn, scope); err != nil {
+			addErr(err)
+		}
+		tn.typ = typeFunc
+	case *funcStmtOrExpr:
+		if nn.declare.name != "" && !nn.IsExpr() {
+			scope.setFunc(nn.declare.name, nn.declare)
+		}
+		if err := checkFuncTypes(nn.declare, scope); err != nil {
+			addErr(err)
+		}
+		scope.push()
+		for i := range nn.declare.args {
+			arg := &nn.declare.args[i]
+			if arg.defaultVal != nil {
+				infer(arg.defaultVal)
+			}
+			if id, ok := arg.left.TerminalNode().(*identifierNode); ok {
+				switch {
+				case arg.variadic:
+					scope.setDeclaredType(id.value, &listType{scope.resolveType(arg.typ)})
+				case arg.typ != nil:
+					scope.setDeclaredType(id.value, scope.resolveType(arg.typ))
+				case arg.defaultVal != nil:
+					scope.setType(id.value, typeOf(arg.defaultVal))
+				default:
+					scope.setType(id.value, typeUnknown)
+				}
+			}
+		}
+		errs = append(errs, a.inferBody(nn.body, scope, nn.declare)...)
+		if !nn.bodyIsStmt && len(nn.body) > 0 {
+			if err := a.checkReturnType(nn.declare, nn.body[0], scope); err != nil {
+				addErr(err)
+			}
+		}
+		scope.pop()
+		tn.typ = typeFunc
+	case *returnStatement:
+		infer(nn.left)
+		if fn != nil {
+			if err := a.checkReturnType(fn, nn.left, scope); err != nil {
+				addErr(err)
+			}
+		}
+	case *constStatement:
+		infer(nn.right)
+		errs = append(errs, a.declareTypes(nn, scope)...)
+	case *letAssignStatement:
+		infer(nn.right)
+		errs = append(errs, a.declareTypes(nn, scope)...)
+	case *letDeclareStatement:
+		for i := range nn.left {
+			if name := scope.undefinedType(nn.left[i].typ); name != "" {
+				addErr(fmt.Errorf("undefined type: %s", name))
+			}
+			if id, ok := nn.left[i].left.TerminalNode().(*identifierNode); ok {
+				scope.setDeclaredType(id.value, scope.resolveType(nn.left[i].typ))
+			}
+		}
+	case *typeDeclareStatement:
+		if name := scope.undefinedType(nn.typ); name != "" {
+			addErr(fmt.Errorf("undefined type: %s", name))
+		}
+	case *enumStatement:
+		if a.enums[nn.typ.name] != nn.typ {
+			addErr(fmt.Errorf("enum %s must be declared at top level", nn.typ.name))
+		}
+	case *assignExpr:
+		infer(nn.left, nn.right)
+		if id, ok := nn.left.TerminalNode().(*identifierNode); ok {
+			if decl := scope.getOuterDeclaredType(id.value); decl != nil {
+				if from := typeExprOf(nn.right); !isAssignableType(decl, from) {
+					addErr(fmt.Errorf("cannot use %s as %s value in assignment", from, decl))
+				} else if err := a.checkRecordValue(decl, nn.right, scope); err != nil {
+					addErr(err)
+				}
+			} else if to, _ := scope.getOuterType(id.value); !isAssignable(to, typeOf(nn.right)) {
+				addErr(fmt.Errorf("cannot use %s as %s value in assignment", typeOf(nn.right), to))
+			}
+		}
+		tn.typ = typeOf(nn.right)
+	case *compoundAssignExpr:
+		infer(nn.left, nn.right)
+		l, r := typeOf(nn.left), typeOf(nn.right)
+		if id, ok := nn.left.TerminalNode().(*identifierNode); ok {
+			l, _ = scope.getOuterType(id.value)
+		}
+		typ, err := inferCompoundAssignType(nn.op, l, r)
+		if err != nil {
+			addErr(err)
+		} else if !isAssignable(l, typ) {
+			addErr(fmt.Errorf("cannot use %s as %s value in assignment", typ, l))
+		}
+		tn.typ = typ
+	case *ifStatement:
+		infer(nn.cond)
+		errs = append(errs, a.inferBody(nn.body, scope, fn)...)
+		errs = append(errs, a.inferBody(nn.els, scope, fn)...)
+	case *whileStatement:
+		infer(nn.cond)
+		errs = append(errs, a.inferBody(nn.body, scope, fn)...)
+	case *forStatement:
+		infer(nn.right)
+		elemType := typeUnknown
+		switch typ := typeOf(nn.right); typ {
+		case typeUnknown:
+		case typeList:
+			if t, ok := typeExprOf(nn.right).(*listType); ok {
+				elemType = declaredType(t.elem)
+			}
+		case typeString:
+			elemType = typeString
+		default:
+			addErr(fmt.Errorf("cannot iterate over %s", typ))
+		}
+		scope.push()
+		switch left := nn.left.TerminalNode().(type) {
+		case *identifierNode:
+			scope.setType(left.value, elemType)
+		case *dictionaryNode:
+			if nn.pairs {
+				// "for {key, value} in items(d)" destructures [key, value] pairs.
+				if err := checkPairPattern(left); err != nil {
+					addErr(err)
+				}
+			} else if elemType == typeString || elemType == typeList {
+				addErr(fmt.Errorf("cannot destructure %s by dictionary pattern", elemType))
+			}
+			for _, id := range nn.GetLeftIdentifiers() {
+				if id, ok := id.TerminalNode().(*identifierNode); ok {
+					scope.setType(id.value, typeUnknown)
+				}
+			}
+		default:
+			if elemType == typeString {
+				addErr(fmt.Errorf("cannot destructure %s", elemType))
+			}
+			for _, id := range nn.GetLeftIdentifiers() {
+				if id, ok := id.TerminalNode().(*identifierNode); ok {
+					scope.setType(id.value, typeUnknown)
+				}
+			}
+		}
+		errs = append(errs, a.inferBody(nn.body, scope, fn)...)
+		scope.pop()
+	case *tryStatement:
+		errs = append(errs, a.inferBody(nn.body, scope, fn)...)
+		for i := range nn.catches {
+			scope.push()
+			if nn.catches[i].left != nil {
+				if id, ok := nn.catches[i].left.TerminalNode().(*identifierNode); ok {
+					scope.setType(id.value, typeString) // v:exception
+				}
+			}
+			errs = append(errs, a.inferBody(nn.catches[i].body, scope, fn)...)
+			scope.pop()
+		}
+		errs = append(errs, a.inferBody(nn.finally, scope, fn)...)
+	case *throwStatement:
+		infer(nn.left)
+	case *matchStatement:
+		infer(nn.left)
+		typ := typeOf(nn.left)
+		var matchesAny bool
+		for i := range nn.arms {
+			if matchesAny {
+				errs = append(errs, *a.err(errors.New("unreachable match arm"), nn.arms[i].pattern))
+			}
+			scope.push()
+			errs = append(errs, a.inferPattern(nn.arms[i].pattern, typ, scope)...)
+			errs = append(errs, a.inferBody(nn.arms[i].body, scope, fn)...)
+			scope.pop()
+			if _, ok := nn.arms[i].pattern.TerminalNode().(*identifierNode); ok {
+				matchesAny = true
+			}
+		}
+	case *executeStatement:
+		infer(nn.left)
+	case *mapStatement:
+		infer(nn.callback)
+		if err := a.checkExCallback(nn.callback, "0", scope); err != nil {
+			errs = append(errs, *a.err(err, nn.callback))
+		}
+	case *commandStatement:
+		infer(nn.callback)
+		if err := a.checkExCallback(nn.callback, nn.nargs(), scope); err != nil {
+			errs = append(errs, *a.err(err, nn.callback))
+		}
+	case *autocmdStatement:
+		infer(nn.callback)
+		if err := a.checkExCallback(nn.callback, "0", scope); err != nil {
+			errs = append(errs, *a.err(err, nn.callback))
+		}
+	case *augroupStatement:
+		for i := range nn.body {
+			infer(nn.body[i])
+		}
+	case *ternaryNode:
+		infer(nn.cond, nn.left, nn.right)
+		if l, r := typeOf(nn.left), typeOf(nn.right); l == r {
+			tn.typ = l
+		}
+	case *orNode, *andNode:
+		op := nn.(binaryOpNode)
+		infer(op.Left(), op.Right())
+		tn.typ = typeBool
+	case *equalNode, *equalCiNode, *nequalNode, *nequalCiNode,
+		*greaterNode, *greaterCiNode, *gequalNode, *gequalCiNode,
+		*smallerNode, *smallerCiNode, *sequalNode, *sequalCiNode,
+		*matchNode, *matchCiNode, *noMatchNode, *noMatchCiNode,
+		*isNode, *isCiNode, *isNotNode, *isNotCiNode:
+		op := nn.(binaryOpNode)
+		infer(op.Left(), op.Right())
+		tn.typ = typeBool
+	case *addNode:
+		infer(nn.left, nn.right)
+		l, r := typeOf(nn.left), typeOf(nn.right)
+		if l == typeList && r == typeList { // List concatenation
+			tn.typ = typeList
+			break
+		}
+		typ, err := inferArithmeticType("+", l, r)
+		if err != nil {
+			addErr(err)
+		}
+		tn.typ = typ
+	case *concatNode:
+		infer(nn.left, nn.right)
+		for _, typ := range []string{typeOf(nn.left), typeOf(nn.right)} {
+			if typ != typeUnknown && typ != typeString && typ != typeInt {
+				addErr(fmt.Errorf(
+					"invalid operation: %s .. %s (operator .. is defined only on String and Int)",
+					typeOf(nn.left), typeOf(nn.right),
+				))
+				break
+			}
+		}
+		tn.typ = typeString
+	case *subtractNode, *multiplyNode, *divideNode:
+		op := nn.(binaryOpNode)
+		infer(op.Left(), op.Right())
+		typ, err := inferArithmeticType(opString(nn), typeOf(op.Left()), typeOf(op.Right()))
+		if err != nil {
+			addErr(err)
+		}
+		tn.typ = typ
+	case *remainderNode:
+		infer(nn.left, nn.right)
+		l, r := typeOf(nn.left), typeOf(nn.right)
+		if l != typeUnknown && r != typeUnknown && (l != typeInt || r != typeInt) {
+			addErr(fmt.Errorf("invalid operation: %s %% %s (operator %% is defined only on Int)", l, r))
+		}
+		tn.typ = typeInt
+	case *notNode:
+		infer(nn.left)
+		tn.typ = typeBool
+	case *minusNode, *plusNode:
+		op := nn.(unaryOpNode)
+		infer(op.Value())
+		typ := typeOf(op.Value())
+		if typ != typeUnknown && !isNumericType(typ) {
+			addErr(fmt.Errorf("invalid operation: %s%s (operator %s not defined on %s)",
+				opString(nn), typ, opString(nn), typ))
+			typ = typeUnknown
+		}
+		tn.typ = typ
+	case *sliceNode:
+		infer(nn.left)
+		for i := range nn.rlist {
+			infer(nn.rlist[i])
+		}
+		switch typ := typeOf(nn.left); typ {
+		case typeUnknown, typeString, typeList:
+			tn.typ = typ
+		default:
+			addErr(fmt.Errorf("cannot slice %s", typ))
+		}
+		for i := range nn.rlist {
+			if typ := typeOf(nn.rlist[i]); nn.rlist[i] != nil && !isAssignable(typeInt, typ) {
+				addErr(fmt.Errorf("invalid slice index (type %s)", typ))
+			}
+		}
+	case *callNode:
+		infer(nn.left)
+		for i := range nn.rlist {
+			infer(nn.rlist[i])
+		}
+		ret, e := a.inferCallType(nn, scope)
+		if len(e) > 0 {
+			errs = append(errs, e...)
+		}
+		tn.decl = ret
+		tn.typ = declaredType(ret)
+	case *subscriptNode:
+		infer(nn.left, nn.right)
+		switch typ := typeOf(nn.left); typ {
+		case typeUnknown, typeList, typeDict:
+		case typeString:
+			tn.typ = typeString
+		default:
+			addErr(fmt.Errorf("cannot index %s", typ))
+		}
+		if typ := typeOf(nn.left); typ == typeList || typ == typeString {
+			if idx := typeOf(nn.right); !isAssignable(typeInt, idx) {
+				addErr(fmt.Errorf("invalid index of %s (type %s)", typ, idx))
+			}
+		}
+	case *dotNode:
+		infer(nn.left)
+		var field string
+		if id, ok := nn.right.TerminalNode().(*identifierNode); ok {
+			field = id.value
+		}
+		if path := a.packageOf(nn.left, scope); path != "" {
+			if f, loaded := a.getImportedFunc(path, field); f != nil {
+				tn.typ = typeFunc
+			} else if loaded {
+				errs = append(errs, *a.err(fmt.Errorf("%s is not declared in '%s'", field, path), nn.right))
+			}
+		} else if e := a.enumOf(nn.left, scope); e != nil {
+			if e.getVariant(field) == nil {
+				addErr(fmt.Errorf("enum %s has no variant %s", e, field))
+			}
+			tn.typ = e.valueType()
+		} else if typ := typeOf(nn.left); typ != typeUnknown && typ != typeDict {
+			addErr(fmt.Errorf("%s has no field %s", typ, field))
+		} else if rec := a.recordTypeOf(nn.left, scope); rec != nil {
+			if f := rec.getField(field); f != nil {
+				tn.decl = scope.resolveType(f.typ)
+				tn.typ = declaredType(tn.decl)
+			} else {
+				addErr(fmt.Errorf("%s has no field %s", rec, field))
+			}
+		}
+	case *identifierNode:
+		if nn.isVarname {
+			var found bool
+			tn.typ, found = scope.getOuterType(nn.value)
+			tn.decl = scope.getOuterDeclaredType(nn.value)
+			if !found && a.getBuiltinFunc(nn.value) != nil {
+				tn.typ = typeFunc
+			}
+		}
+	case *intNode:
+		tn.typ = typeInt
+	case *floatNode:
+		tn.typ = typeFloat
+	case *boolNode:
+		tn.typ = typeBool
+	case *noneNode:
+		tn.typ = typeNone
+	case *stringNode:
+		tn.typ = typeString
+	case *templateNode:
+		for i := range nn.exprs {
+			infer(nn.exprs[i])
+		}
+		tn.typ = typeString
+	case *listNode:
+		for i := range nn.value {
+			infer(nn.value[i])
+		}
+		tn.typ = typeList
+		if elem := elementType(nn.value); elem != nil {
+			tn.decl = &listType{elem}
+		}
+	case *dictionaryNode:
+		for i := range nn.value {
+			// An identifier key is a string literal, not a variable.
+			if _, ok := nn.value[i][0].TerminalNode().(*identifierNode); !ok {
+				infer(nn.value[i][0])
+			}
+			infer(nn.value[i][1])
+		}
+		tn.typ = typeDict
+		values := make([]expr, len(nn.value))
+		for i := range nn.value {
+			values[i] = nn.value[i][1]
+		}
+		if value := elementType(values); value != nil {
+			tn.decl = &dictType{value}
+		}
+	case *envNode:
+		tn.typ = typeString
+	case *regNode:
+		tn.typ = typeString
+	}
+	return errs
+}
+
+// declareTypes sets the types of left-hand side variables
+// of declaration to the scope.
+func (a *analyzer) declareTypes(n assignNode, scope *Scope) []node.ErrorNode {
+	right := n.Right()
+	if typeOf(right) == typeVoid {
+		err := a.err(errors.New("cannot use Void as value"), right)
+		return []node.ErrorNode{*err}
+	}
+	switch left := n.Left().TerminalNode().(type) {
+	case *identifierNode:
+		if f, ok := right.TerminalNode().(*funcStmtOrExpr); ok {
+			scope.setFunc(left.value, f.declare)
+		} else if _, ok := n.(*constStatement); ok && typeExprOf(right) != nil {
+			// Constants keep the type of the value (e.g. List<String>)
+			// because they are never reassigned.
+			scope.setDeclaredType(left.value, typeExprOf(right))
+		} else {
+			scope.setType(left.value, typeOf(right))
+		}
+	case *listNode: // Destructuring
+		if typ := typeOf(right); typ != typeUnknown && typ != typeList {
+			err := a.err(fmt.Errorf("cannot destructure %s", typ), right)
+			return []node.ErrorNode{*err}
+		}
+		for _, id := range n.GetLeftIdentifiers() {
+			if id, ok := id.TerminalNode().(*identifierNode); ok {
+				scope.setType(id.value, typeUnknown)
+			}
+		}
+		if left.rest {
+			// The rest element is the list of the remaining elements.
+			last := left.value[len(left.value)-1]
+			if id, ok := last.TerminalNode().(*identifierNode); ok {
+				scope.setType(id.value, typeList)
+			}
+		}
+	case *dictionaryNode: // Destructuring
+		if typ := typeOf(right); typ != typeUnknown && typ != typeDict {
+			err := a.err(fmt.Errorf("cannot destructure %s", typ), right)
+			return []node.ErrorNode{*err}
+		}
+		rec := a.recordTypeOf(right, scope)
+		errs := make([]node.ErrorNode, 0, 4)
+		for i := range left.value {
+			key, ok1 := left.value[i][0].TerminalNode().(*identifierNode)
+			id, ok2 := left.value[i][1].TerminalNode().(*identifierNode)
+			if !ok1 || !ok2 {
+				continue
+			}
+			if rec == nil {
+				scope.setType(id.value, typeUnknown)
+			} else if f := rec.getField(key.value); f != nil {
+				scope.setDeclaredType(id.value, scope.resolveType(f.typ))
+			} else {
+				err := a.err(fmt.Errorf("%s has no field %s", rec, key.value), left.value[i][0])
+				errs = append(errs, *err)
+			}
+		}
+		return errs
+	}
+	return nil
+}
+
+// checkPairPattern checks if the dictionary pattern destructures
+// [key, value] pairs (e.g. "for {key, value: v} in items(d)").
+func checkPairPattern(pattern *dictionaryNode) error {
+	for i := range pattern.value {
+		key, ok := pattern.value[i][0].TerminalNode().(*identifierNode)
+		if ok && key.value != "key" && key.value != "value" {
+			return fmt.Errorf("cannot destructure [key, value] pair by %s (must be key or value)", key.value)
+		}
+	}
+	return nil
+}
+
+// inferCallType returns the return type of the function call
+// (nil if it is unknown).
+// If the signature of the function is known, it also checks
+// the number of arguments and the types of them.
+func (a *analyzer) inferCallType(n *callNode, scope *Scope) (typeExpr, []node.ErrorNode) {
+	typ := typeOf(n.left)
+	if typ != typeUnknown && typ != typeFunc {
+		err := a.err(fmt.Errorf("cannot call non-function (type %s)", typ), n.left)
+		return nil, []node.ErrorNode{*err}
+	}
+	var name string
+	var f *funcDeclareStatement
+	switch left := n.left.TerminalNode().(type) {
+	case *identifierNode:
+		if !left.isVarname {
+			return nil, nil
+		}
+		name = left.value
+		if _, found := scope.getOuterType(name); found {
+			f = scope.getOuterFunc(name)
+		} else {
+			f = a.getBuiltinFunc(name)
+		}
+	case *dotNode:
+		// The function of imported package (e.g. "pkg.f()").
+		pkg, ok1 := left.left.TerminalNode().(*identifierNode)
+		id, ok2 := left.right.TerminalNode().(*identifierNode)
+		path := a.packageOf(left.left, scope)
+		if !ok1 || !ok2 || path == "" {
+			return nil, nil
+		}
+		name = pkg.value + "." + id.value
+		f, _ = a.getImportedFunc(path, id.value)
+	}
+	if f == nil {
+		return nil, nil
+	}
+
+	errs := make([]node.ErrorNode, 0, 4)
+	min, max, variadic := funcArity(f)
+	if len(n.rlist) < min || (!variadic && len(n.rlist) > max) {
+		msg := "not enough"
+		if len(n.rlist) > max {
+			msg = "too many"
+		}
+		err := a.err(fmt.Errorf(
+			"%s arguments in call to %s (have %d, want %s)",
+			msg, name, len(n.rlist), arityString(min, max, variadic),
+		), n.left)
+		errs = append(errs, *err)
+	}
+	for i := range n.rlist {
+		arg := i
+		if variadic && arg >= max-1 {
+			arg = max - 1 // Each rest argument has the type of variadic argument.
+		} else if arg >= max {
+			break
+		}
+		to := scope.resolveType(f.args[arg].typ)
+		if to == nil && f.args[arg].defaultVal != nil {
+			if typ := typeOf(f.args[arg].defaultVal); typ != typeUnknown {
+				to = &namedType{typ}
+			}
+		}
+		if from := typeExprOf(n.rlist[i]); !isAssignableType(to, from) {
+			err := a.err(fmt.Errorf(
+				"cannot use %s as %s value in argument %d to %s", from, to, i+1, name,
+			), n.rlist[i])
+			errs = append(errs, *err)
+			continue
+		}
+		if err := a.checkRecordValue(to, n.rlist[i], scope); err != nil {
+			errs = append(errs, *a.err(err, n.rlist[i]))
+			continue
+		}
+		if ft, ok := to.(*funcType); ok {
+			if g := a.getFuncValue(n.rlist[i], scope); g != nil && !checkFuncValue(ft, g) {
+				err := a.err(fmt.Errorf(
+					"cannot use %s (type %s) as %s value in argument %d to %s",
+					g.name, funcTypeOf(g), ft, i+1, name,
+				), n.rlist[i])
+				errs = append(errs, *err)
+			}
+		}
+	}
+	return scope.resolveType(f.retType), errs
+}
+
+// getFuncValue returns the declaration of the function
+// if n is the variable of named function.
+func (a *analyzer) getFuncValue(n node.Node, scope *Scope) *funcDeclareStatement {
+	id, ok := n.TerminalNode().(*identifierNode)
+	if !ok || !id.isVarname {
+		return nil
+	}
+	if _, found := scope.getOuterType(id.value); !found {
+		return nil
+	}
+	if f := scope.getOuterFunc(id.value); f != nil && f.name != "" {
+		return f
+	}
+	return nil
+}
+
+// funcArity returns the number of required arguments, and
+// the number of all arguments of f.
+// variadic is true if f has the variadic argument.
+func funcArity(f *funcDeclareStatement) (min, max int, variadic bool) {
+	for i := range f.args {
+		if f.args[i].defaultVal == nil && !f.args[i].variadic {
+			min = i + 1
+		}
+	}
+	max = len(f.args)
+	variadic = max > 0 && f.args[max-1].variadic
+	return
+}
+
+// arityString returns the number of arguments for error messages.
+func arityString(min, max int, variadic bool) string {
+	if variadic {
+		return fmt.Sprintf("%d or more", min)
+	} else if min != max {
+		return fmt.Sprintf("%d to %d", min, max)
+	}
+	return strconv.Itoa(min)
+}
+
+// checkExCallback checks if the callback of Ex command DSL is
+// a named function which accepts the arguments passed by the command.
+// nargs is the value of -nargs option of command ("0" for map and autocmd).
+func (a *analyzer) checkExCallback(callback node.Node, nargs string, scope *Scope) error {
+	id, ok := callback.TerminalNode().(*identifierNode)
+	if !ok {
+		return nil
+	}
+	typ, found := scope.getOuterType(id.value)
+	if !found {
+		if a.getBuiltinFunc(id.value) != nil {
+			return fmt.Errorf("cannot use builtin function %s as callback", id.value)
+		}
+		return nil // undefined variable is reported by checkVariable()
+	}
+	f := scope.getOuterFunc(id.value)
+	if f == nil {
+		if typ != typeUnknown && typ != typeFunc {
+			return fmt.Errorf("cannot use %s (type %s) as callback", id.value, typ)
+		}
+		return nil
+	}
+	if f.name == "" {
+		return fmt.Errorf("cannot use anonymous function %s as callback", id.value)
+	}
+	min, max, variadic := funcArity(f)
+	var matched bool
+	switch nargs {
+	case "0":
+		matched = min == 0
+	case "1":
+		matched = min <= 1 && (max >= 1 || variadic)
+	case "?":
+		matched = min == 0 && (max >= 1 || variadic)
+	case "*":
+		matched = min == 0 && variadic
+	case "+":
+		matched = min <= 1 && variadic
+	}
+	if matched {
+		return nil
+	}
+	want := arityString(min, max, variadic)
+	if nargs == "0" {
+		return fmt.Errorf("callback %s must be callable without arguments (want %s)", id.value, want)
+	}
+	return fmt.Errorf("-nargs=%s does not match the arguments of %s (want %s)", nargs, id.value, want)
+}
+
+// inferArithmeticType returns the result type of arithmetic operator.
+// It reports an error only if the both operand types are known.
+func inferArithmeticType(op, l, r string) (string, error) {
+	switch {
+	case (op == "+" || op == "+=") && (l == typeString || r == typeString):
+		// Vim converts String to Number with "+", which is almost a bug.
+		return typeUnknown, fmt.Errorf(
+			"invalid operation: %s %s %s (operator %s not defined on String, use .. to concatenate)",
+			l, op, r, op,
+		)
+	case l == typeUnknown || r == typeUnknown:
+		return typeUnknown, nil
+	case !isNumericType(l) || !isNumericType(r):
+		return typeUnknown, fmt.Errorf("invalid operation: %s %s %s (mismatched types)", l, op, r)
+	case l == typeFloat || r == typeFloat:
+		return typeFloat, nil
+	}
+	return typeInt, nil
+}
+
+// inferCompoundAssignType returns the type of "l op r"
+// where op is a compound assignment operator (e.g. "+=").
+func inferCompoundAssignType(op, l, r string) (string, error) {
+	switch op {
+	case "+=":
+		if l == typeList && r == typeList { // List concatenation
+			return typeList, nil
+		}
+		return inferArithmeticType(op, l, r)
+	case "%=":
+		if l != typeUnknown && r != typeUnknown && (l != typeInt || r != typeInt) {
+			return typeUnknown, fmt.Errorf("invalid operation: %s %%= %s (operator %%= is defined only on Int)", l, r)
+		}
+		return typeInt, nil
+	case "..=":
+		if l != typeUnknown && l != typeString {
+			return typeUnknown, fmt.Errorf("invalid operation: %s ..= %s (operator ..= is defined only on String)", l, r)
+		}
+		return typeString, nil
+	default:
+		return inferArithmeticType(op, l, r)
+	}
+}
+
+// checkReturnType checks if the value can be returned from the function f.
+// value is nil if the return statement has no value.
+func (a *analyzer) checkReturnType(f *funcDeclareStatement, value node.Node, scope *Scope) error {
+	retType := scope.resolveType(f.retType)
+	switch {
+	case retType == nil:
+		return nil
+	case declaredType(retType) == typeVoid:
+		if typ := typeOf(value); value != nil && typ != typeUnknown && typ != typeVoid {
+			return errors.New("too many return values: function returns Void")
+		}
+		return nil
+	case value == nil:
+		return fmt.Errorf("not enough return values: function returns %s", f.retType)
+	}
+	if typ := typeExprOf(value); !isAssignableType(retType, typ) {
+		return fmt.Errorf("cannot use %s as %s value in return statement", typ, f.retType)
+	}
+	return a.checkRecordValue(retType, value, scope)
+}
+
+// checkFuncTypes checks if the types of arguments and return value of f are defined.
+func checkFuncTypes(f *funcDeclareStatement, scope *Scope) error {
+	for i := range f.args {
+		if name := scope.undefinedType(f.args[i].typ); name != "" {
+			return fmt.Errorf("undefined type: %s", name)
+		}
+	}
+	if name := scope.undefinedType(f.retType); name != "" {
+		return fmt.Errorf("undefined type: %s", name)
+	}
+	return nil
+}
+
+// inferPattern checks if the pattern can match the value of type typ,
+// and sets the types of the variables bound by the pattern.
+func (a *analyzer) inferPattern(pattern node.Node, typ string, scope *Scope) []node.ErrorNode {
+	var patType string
+	switch p := pattern.TerminalNode().(type) {
+	case *identifierNode:
+		if p.value != "_" {
+			scope.setType(p.value, typ)
+		}
+		return nil
+	case *listNode:
+		if typ != typeUnknown && typ != typeList {
+			return []node.ErrorNode{*a.err(fmt.Errorf("cannot match %s with list pattern", typ), pattern)}
+		}
+		errs := make([]node.ErrorNode, 0, 4)
+		for i := range p.value {
+			if p.rest && i == len(p.value)-1 {
+				// The rest element is the list of the remaining elements.
+				errs = append(errs, a.inferPattern(p.value[i], typeList, scope)...)
+				break
+			}
+			errs = append(errs, a.inferPattern(p.value[i], typeUnknown, scope)...)
+		}
+		return errs
+	case *dictionaryNode:
+		if typ != typeUnknown && typ != typeDict {
+			return []node.ErrorNode{*a.err(fmt.Errorf("cannot match %s with dictionary pattern", typ), pattern)}
+		}
+		errs := make([]node.ErrorNode, 0, 4)
+		for i := range p.value {
+			errs = append(errs, a.inferPattern(p.value[i][1], typeUnknown, scope)...)
+		}
+		return errs
+	case *dotNode:
+		if a.enumOf(p.left, scope) == nil {
+			return []node.ErrorNode{*a.err(errors.New("invalid pattern: must be the variant of enum"), pattern)}
+		}
+		if errs := a.inferNode(pattern, scope, nil); len(errs) > 0 {
+			return errs
+		}
+		patType = typeOf(pattern)
+	default: // literal
+		if errs := a.inferNode(pattern, scope, nil); len(errs) > 0 {
+			return errs
+		}
+		patType = typeOf(pattern)
+	}
+	if !isAssignable(typ, patType) && !isAssignable(patType, typ) {
+		return []node.ErrorNode{*a.err(fmt.Errorf("cannot match %s with %s pattern", typ, patType), pattern)}
+	}
+	return nil
+}
+
+// packageOf returns the import path if n is the name of imported package
+// (e.g. "bar" of "import 'foo/bar'"). Otherwise, returns "".
+func (a *analyzer) packageOf(n node.Node, scope *Scope) string {
+	id, ok := n.TerminalNode().(*identifierNode)
+	if !ok || !id.isVarname {
+		return ""
+	}
+	return scope.getOuterPackage(id.value)
+}
+
+// getImportedFunc returns the function declared in the module of
+// the import path. loaded is false if the module is not loaded
+// (e.g. it has errors), so it is unknown whether the function exists.
+func (a *analyzer) getImportedFunc(path, name string) (f *funcDeclareStatement, loaded bool) {
+	f = a.nsdb.getFunc(Namespace(path), name)
+	if f == nil && isBuiltinPkg(path) {
+		f = a.getBuiltinFunc(name)
+	}
+	return f, f != nil || a.nsdb.getNamespace(Namespace(path)) != nil
+}
+
+// enumOf returns the enum if n is the name of enum.
+// The enum can be shadowed by the variable.
+func (a *analyzer) enumOf(n node.Node, scope *Scope) *enumType {
+	id, ok := n.TerminalNode().(*identifierNode)
+	if !ok || !id.isVarname {
+		return nil
+	}
+	if _, found := scope.getOuterType(id.value); found {
+		return nil
+	}
+	return a.enums[id.value]
+}
+
+// recordTypeOf returns the record type of n if it is declared.
+// n is a variable or the field access of record (e.g. "a.b.c").
+func (a *analyzer) recordTypeOf(n node.Node, scope *Scope) *recordType {
+	var t typeExpr
+	switch nn := n.TerminalNode().(type) {
+	case *identifierNode:
+		t = scope.getOuterDeclaredType(nn.value)
+	case *dotNode:
+		rec := a.recordTypeOf(nn.left, scope)
+		id, ok := nn.right.TerminalNode().(*identifierNode)
+		if rec == nil || !ok {
+			return nil
+		}
+		if f := rec.getField(id.value); f != nil {
+			t = scope.resolveType(f.typ)
+		}
+	}
+	if opt, ok := t.(*optionalType); ok {
+		t = opt.elem
+	}
+	rec, _ := t.(*recordType)
+	return rec
+}
+
+// checkRecordValue checks if the dictionary literal value has
+// the fields of the record type t.
+// If t is not a record type or value is not a dictionary literal, does nothing.
+func (a *analyzer) checkRecordValue(t typeExpr, value node.Node, scope *Scope) error {
+	if opt, ok := t.(*optionalType); ok {
+		t = opt.elem
+	}
+	rec, ok := t.(*recordType)
+	if !ok || value == nil {
+		return nil
+	}
+	dict, ok := value.TerminalNode().(*dictionaryNode)
+	if !ok {
+		return nil
+	}
+	keys := make(map[string]bool, len(dict.value))
+	for i := range dict.value {
+		var key string
+		switch k := dict.value[i][0].TerminalNode().(type) {
+		case *identifierNode:
+			key = k.value
+		case *stringNode:
+			s, err := k.value.eval()
+			if err != nil {
+				return nil
+			}
+			key = s
+		default:
+			return nil // cannot check the dictionary which has non-literal keys
+		}
+		keys[key] = true
+		f := rec.getField(key)
+		if f == nil {
+			return fmt.Errorf("unknown field %s in %s literal", key, rec)
+		}
+		ft := scope.resolveType(f.typ)
+		if typ := typeExprOf(dict.value[i][1]); !isAssignableType(ft, typ) {
+			return fmt.Errorf("cannot use %s as %s value in field %s of %s", typ, f.typ, key, rec)
+		}
+		if err := a.checkRecordValue(ft, dict.value[i][1], scope); err != nil {
+			return err
+		}
+	}
+	for i := range rec.fields {
+		if !rec.fields[i].optional && !keys[rec.fields[i].name] {
+			return fmt.Errorf("missing field %s in %s literal", rec.fields[i].name, rec)
+		}
+	}
+	return nil
+}
+
+// opString returns the operator string of arithmetic node.
+func opString(n node.Node) string {
+	switch n.(type) {
+	case *addNode, *plusNode:
+		return "+"
+	case *subtractNode, *minusNode:
+		return "-"
+	case *multiplyNode:
+		return "*"
+	case *divideNode:
+		return "/"
+	case *remainderNode:
+		return "%"
+	}
+	return ""
+}
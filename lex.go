@@ -14,15 +14,17 @@ import (
 //   https://talks.golang.org/2011/lex.slide
 
 type lexer struct {
-	name    string     // Used only for error reports.
-	input   string     // The string being scanned.
-	start   int        // Start position of this item.
-	offset  int        // Current position in the input.
-	width   int        // Width of last rune read from input.
-	prevPos int        // Previous position to restore.
-	tokens  chan token // Channel of scanned items.
-	line    int        // The line number of this item (1-origin).
-	col     int        // The offset from the previous newline (0-origin).
+	name      string     // Used only for error reports.
+	input     string     // The string being scanned.
+	start     int        // Start position of this item.
+	startLine int        // The line number of start (1-origin).
+	startCol  int        // The offset of start from the previous newline (0-origin).
+	offset    int        // Current position in the input.
+	width     int        // Width of last rune read from input.
+	prevPos   int        // Previous position to restore.
+	tokens    chan token // Channel of scanned items.
+	line      int        // The line number of this item (1-origin).
+	col       int        // The offset from the previous newline (0-origin).
 }
 
 type token struct {
@@ -31,6 +33,15 @@ type token struct {
 	val string    // The value of this item.
 }
 
+// end returns the position just after the token.
+// It returns nil if the token is not in one line, or is an error.
+func (t *token) end() *node.Pos {
+	if t.typ == tokenError || strings.Contains(t.val, "\n") {
+		return nil
+	}
+	return node.NewPos(t.pos.Offset()+len(t.val), t.pos.Line(), t.pos.Col()+len(t.val))
+}
+
 type tokenType int
 
 const (
@@ -40,6 +51,12 @@ const (
 	tokenIdentifier
 	tokenComma
 	tokenEqual
+	tokenPlusEqual
+	tokenMinusEqual
+	tokenStarEqual
+	tokenSlashEqual
+	tokenPercentEqual
+	tokenDotDotEqual
 	tokenEqEq
 	tokenEqEqCi
 	tokenColon
@@ -88,6 +105,7 @@ const (
 	tokenMinus
 	tokenArrow
 	tokenDot
+	tokenDotDot
 	tokenDotDotDot
 	tokenConst
 	tokenLet
@@ -101,6 +119,15 @@ const (
 	tokenWhile
 	tokenFor
 	tokenIn
+	tokenTry
+	tokenCatch
+	tokenFinally
+	tokenThrow
+	tokenPattern
+	tokenBreak
+	tokenContinue
+	tokenVim
+	tokenVimBlock
 	tokenComment
 	tokenUnderscore
 )
@@ -119,6 +146,18 @@ func tokenName(typ tokenType) string {
 		return "\",\""
 	case tokenEqual:
 		return "\"=\""
+	case tokenPlusEqual:
+		return "\"+=\""
+	case tokenMinusEqual:
+		return "\"-=\""
+	case tokenStarEqual:
+		return "\"*=\""
+	case tokenSlashEqual:
+		return "\"/=\""
+	case tokenPercentEqual:
+		return "\"%=\""
+	case tokenDotDotEqual:
+		return "\"..=\""
 	case tokenEqEq:
 		return "\"==\""
 	case tokenEqEqCi:
@@ -215,6 +254,8 @@ func tokenName(typ tokenType) string {
 		return "\"->\""
 	case tokenDot:
 		return "\".\""
+	case tokenDotDot:
+		return "\"..\""
 	case tokenDotDotDot:
 		return "\"...\""
 	case tokenConst:
@@ -241,6 +282,24 @@ func tokenName(typ tokenType) string {
 		return "\"for\""
 	case tokenIn:
 		return "\"in\""
+	case tokenTry:
+		return "\"try\""
+	case tokenCatch:
+		return "\"catch\""
+	case tokenFinally:
+		return "\"finally\""
+	case tokenThrow:
+		return "\"throw\""
+	case tokenPattern:
+		return "pattern"
+	case tokenBreak:
+		return "\"break\""
+	case tokenContinue:
+		return "\"continue\""
+	case tokenVim:
+		return "\"vim\""
+	case tokenVimBlock:
+		return "vim block"
 	case tokenComment:
 		return "comment"
 	case tokenUnderscore:
@@ -253,10 +312,11 @@ type lexStateFn func(*lexer) lexStateFn
 
 func lex(name, input string) *lexer {
 	return &lexer{
-		name:   name,
-		input:  input,
-		tokens: make(chan token),
-		line:   1,
+		name:      name,
+		input:     input,
+		tokens:    make(chan token),
+		line:      1,
+		startLine: 1,
 	}
 }
 
@@ -282,6 +342,7 @@ func (l *lexer) eof() bool {
 // next returns the next rune in the input.
 func (l *lexer) next() (r rune) {
 	if l.eof() {
+		l.width = 0 // backup() must not step back at EOF
 		return eof
 	}
 	r, l.width =
@@ -302,7 +363,7 @@ func (l *lexer) nextRunBy(pred func(rune) bool) string {
 	for {
 		r = l.next()
 		if r == eof {
-			return ""
+			break
 		}
 		if !pred(r) {
 			l.backup()
@@ -316,6 +377,7 @@ func (l *lexer) nextRunBy(pred func(rune) bool) string {
 // ignore skips over the pending input before this point.
 func (l *lexer) ignore() {
 	l.start = l.offset
+	l.startLine, l.startCol = l.line, l.col
 }
 
 // ignoreRun skips over the pending input before this point.
@@ -359,7 +421,7 @@ func (l *lexer) backup() {
 func (l *lexer) recalcCol() {
 	nl := strings.LastIndexByte(l.input[:l.offset], '\n')
 	if nl >= 0 {
-		l.col = l.offset - nl
+		l.col = l.offset - nl - 1
 	} else {
 		l.col = l.offset
 	}
@@ -450,24 +512,17 @@ func (l *lexer) acceptKeyword(kw string, boundary bool) bool {
 
 // emit passes an token back to the client.
 func (l *lexer) emit(t tokenType) {
-	pos := node.NewPos(l.offset, l.line, l.col)
+	pos := node.NewPos(l.start, l.startLine, l.startCol)
 	l.tokens <- token{t, pos, l.input[l.start:l.offset]}
-	l.start = l.offset
+	l.ignore()
 }
 
 // errorf returns an error token and terminates the scan
 // by passing back a nil pointer that will be the next
 // state, terminating l.Run.
 func (l *lexer) errorf(format string, args ...interface{}) lexStateFn {
-	newargs := make([]interface{}, 0, len(args)+3)
-	newargs = append(newargs, l.name, l.line, l.col+1)
-	newargs = append(newargs, args...)
 	pos := node.NewPos(l.offset, l.line, l.col)
-	l.tokens <- token{
-		tokenError,
-		pos,
-		fmt.Sprintf("[lex] %s:%d:%d: "+format, newargs...),
-	}
+	l.tokens <- token{tokenError, pos, fmt.Sprintf(format, args...)}
 	return nil
 }
 
@@ -592,12 +647,24 @@ func lexTop(l *lexer) lexStateFn {
 		l.emit(tokenQuestion)
 		return lexTop
 	case '*':
+		if l.accept("=") {
+			l.emit(tokenStarEqual)
+			return lexTop
+		}
 		l.emit(tokenStar)
 		return lexTop
 	case '/':
+		if l.accept("=") {
+			l.emit(tokenSlashEqual)
+			return lexTop
+		}
 		l.emit(tokenSlash)
 		return lexTop
 	case '%':
+		if l.accept("=") {
+			l.emit(tokenPercentEqual)
+			return lexTop
+		}
 		l.emit(tokenPercent)
 		return lexTop
 	case ',':
@@ -623,6 +690,10 @@ func lexTop(l *lexer) lexStateFn {
 		l.emit(tokenEqual)
 		return lexTop
 	case '+':
+		if l.accept("=") {
+			l.emit(tokenPlusEqual)
+			return lexTop
+		}
 		l.emit(tokenPlus)
 		return lexTop
 	case '-':
@@ -630,6 +701,10 @@ func lexTop(l *lexer) lexStateFn {
 			l.emit(tokenArrow)
 			return lexTop
 		}
+		if l.accept("=") {
+			l.emit(tokenMinusEqual)
+			return lexTop
+		}
 		l.emit(tokenMinus)
 		return lexTop
 	case '.':
@@ -637,6 +712,14 @@ func lexTop(l *lexer) lexStateFn {
 			l.emit(tokenDotDotDot)
 			return lexTop
 		}
+		if l.acceptKeyword(".=", false) {
+			l.emit(tokenDotDotEqual)
+			return lexTop
+		}
+		if l.accept(".") {
+			l.emit(tokenDotDot)
+			return lexTop
+		}
 		l.emit(tokenDot)
 		return lexTop
 	case ':':
@@ -702,6 +785,31 @@ func lexTop(l *lexer) lexStateFn {
 	case "in":
 		l.emit(tokenIn)
 		return lexTop
+	case "try":
+		l.emit(tokenTry)
+		return lexTop
+	case "catch":
+		l.emit(tokenCatch)
+		return lexCatchPattern
+	case "finally":
+		l.emit(tokenFinally)
+		return lexTop
+	case "throw":
+		l.emit(tokenThrow)
+		return lexTop
+	case "break":
+		l.emit(tokenBreak)
+		return lexTop
+	case "continue":
+		l.emit(tokenContinue)
+		return lexTop
+	case "vim":
+		// "vim" is a keyword only when "{" follows.
+		// Otherwise it is an identifier.
+		if l.isVimBlock() {
+			l.emit(tokenVim)
+			return lexVimBlock
+		}
 	case "true", "false":
 		l.emit(tokenBool)
 		return lexTop
@@ -721,16 +829,80 @@ func lexTop(l *lexer) lexStateFn {
 	return l.errorf("unknown token")
 }
 
+// lexCatchPattern scans a pattern after "catch" keyword (e.g. "/^Vim:/").
+// A pattern can't be scanned in lexTop because "/" is also an operator.
+func lexCatchPattern(l *lexer) lexStateFn {
+	l.ignoreRun(" \t")
+	if !l.accept("/") {
+		return lexTop
+	}
+	for {
+		switch l.next() {
+		case '\\':
+			if r := l.next(); r == eof || r == '\n' {
+				return l.errorf("unterminated pattern")
+			}
+		case '/':
+			l.emit(tokenPattern)
+			return lexTop
+		case eof, '\n':
+			return l.errorf("unterminated pattern")
+		}
+	}
+}
+
+// isVimBlock returns true if "{" follows after blanks.
+func (l *lexer) isVimBlock() bool {
+	l.save()
+	l.acceptRun(" \t")
+	ok := l.peek() == '{'
+	l.restore()
+	return ok
+}
+
+// lexVimBlock scans the lines of Vim script after "vim {".
+// The block ends at the line which has only "}".
+// The lines are emitted as one token without "{" and "}".
+func lexVimBlock(l *lexer) lexStateFn {
+	l.ignoreRun(" \t")
+	if !l.accept("{") {
+		return l.errorf("expected \"{\" after \"vim\"")
+	}
+	l.acceptRun(" \t")
+	if !l.accept("\n") {
+		return l.errorf("expected newline after \"vim {\"")
+	}
+	l.ignore()
+	for {
+		l.save()
+		begin := l.offset
+		r := l.next()
+		for r != '\n' && r != eof {
+			r = l.next()
+		}
+		if strings.TrimSpace(l.input[begin:l.offset]) == "}" {
+			l.restore()
+			l.emit(tokenVimBlock)
+			l.acceptRun(" \t\r}")
+			l.ignore()
+			return lexTop
+		}
+		if r == eof {
+			return l.errorf("unterminated vim block")
+		}
+	}
+}
+
 func lexNumber(l *lexer) lexStateFn {
 	digits := "0123456789"
 	if l.accept("0") && l.accept("xX") {
 		digits = "0123456789abcdefABCDEF"
 	}
 	l.acceptRun(digits)
-	if l.accept(".eE") {
-		if l.accept(".") {
-			l.acceptRun(digits)
-		}
+	// A float literal needs digits after ".", e.g. "1.0", "1.0e-3" (:help floating-point-format)
+	l.save()
+	if l.accept(".") && l.acceptBy(isNumeric) {
+		l.acceptRun("0123456789")
 		if l.accept("eE") {
 			l.accept("+-")
 			l.acceptRun("0123456789")
@@ -739,7 +911,7 @@ func lexNumber(l *lexer) lexStateFn {
 			l.emit(tokenFloat)
 			return lexTop
 		}
-	} else if !isAlphaNumeric(l.peek()) {
+	} else if l.restore(); !isAlphaNumeric(l.peek()) {
 		l.emit(tokenInt)
 		return lexTop
 	}
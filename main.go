@@ -2,12 +2,15 @@ package main
 
 import (
 	"bufio"
+	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 
@@ -30,22 +33,210 @@ func main() {
 		usage()
 	}
 	if err != nil {
-		fmt.Fprintln(os.Stderr, err.Error())
+		if err != errReported {
+			printErrors(os.Stderr, flattenErrors(err), diagnosticsText)
+		}
 		os.Exit(1)
 	}
 }
 
+// errReported is returned by the commands
+// when the errors were already printed.
+var errReported = errors.New("errors were reported")
+
+// reportErrors prints errs to w sorted by file path, line, and column.
+// If maxErrors > 0, at most maxErrors errors are printed.
+// In text format, the summary line is printed at last.
+func reportErrors(w io.Writer, errs []error, format diagnosticsFormat, maxErrors int) {
+	sortErrors(errs)
+	shown := errs
+	if maxErrors > 0 && len(errs) > maxErrors {
+		shown = errs[:maxErrors]
+	}
+	printErrors(w, shown, format)
+	if format == diagnosticsText {
+		fmt.Fprintln(w, summarizeErrors(errs, len(shown)))
+	}
+}
+
+// sortErrors sorts errs by file path, line, and column.
+// The errors which are not diagnostics (e.g. I/O errors) come first.
+func sortErrors(errs []error) {
+	type key struct {
+		file      string
+		line, col int
+		message   string
+	}
+	keys := make(map[error]key, len(errs))
+	for _, e := range errs {
+		d := toDiagnostic(e)
+		k := key{file: d.file, message: d.message}
+		if d.start != nil {
+			k.line, k.col = d.start.Line(), d.start.Col()
+		}
+		keys[e] = k
+	}
+	sort.SliceStable(errs, func(i, j int) bool {
+		a, b := keys[errs[i]], keys[errs[j]]
+		if a.file != b.file {
+			return a.file < b.file
+		}
+		if a.line != b.line {
+			return a.line < b.line
+		}
+		if a.col != b.col {
+			return a.col < b.col
+		}
+		return a.message < b.message
+	})
+}
+
+// summarizeErrors returns the summary line of errs like "3 errors in 2 files"
+// or "2 errors and 1 warning in 2 files".
+// shown is the number of the printed errors.
+func summarizeErrors(errs []error, shown int) string {
+	files := make(map[string]bool, 8)
+	warnings := 0
+	for _, e := range errs {
+		d := toDiagnostic(e)
+		if d.file != "" {
+			files[d.file] = true
+		}
+		if d.severity == severityWarning {
+			warnings++
+		}
+	}
+	var s string
+	switch {
+	case warnings == 0:
+		s = plural(len(errs), "error")
+	case warnings == len(errs):
+		s = plural(warnings, "warning")
+	default:
+		s = plural(len(errs)-warnings, "error") + " and " + plural(warnings, "warning")
+	}
+	if len(files) > 0 {
+		s += " in " + plural(len(files), "file")
+	}
+	if shown < len(errs) {
+		s += fmt.Sprintf(" (%d not shown)", len(errs)-shown)
+	}
+	return s
+}
+
+// plural returns "1 word" or "n words".
+func plural(n int, word string) string {
+	if n == 1 {
+		return "1 " + word
+	}
+	return fmt.Sprintf("%d %ss", n, word)
+}
+
+// toDiagnostic returns the diagnostic in err.
+// If err is not a diagnostic, it returns the diagnostic which has only the message.
+func toDiagnostic(err error) *diagnostic {
+	var d *diagnostic
+	if !errors.As(err, &d) {
+		d = &diagnostic{message: err.Error()}
+	}
+	return d
+}
+
+// printErrors prints errs to w in the format.
+// In text format, the diagnostics are printed with the source code
+// where the errors occurred.
+func printErrors(w io.Writer, errs []error, format diagnosticsFormat) {
+	sources := make(map[string]string, 8)
+	for _, e := range errs {
+		d := toDiagnostic(e)
+		switch format {
+		case diagnosticsJSON:
+			b, err := json.Marshal(d.record())
+			if err != nil {
+				fmt.Fprintln(w, err.Error())
+				continue
+			}
+			fmt.Fprintln(w, string(b))
+		case diagnosticsQuickfix:
+			fmt.Fprint(w, d.quickfix())
+		default:
+			if d.stage == "" {
+				fmt.Fprintln(w, d.message)
+				continue
+			}
+			src, ok := sources[d.file]
+			if !ok {
+				if b, err := ioutil.ReadFile(d.file); err == nil {
+					src = string(b)
+				}
+				sources[d.file] = src
+			}
+			fmt.Fprint(w, d.render(src))
+		}
+	}
+}
+
+// flattenErrors returns the errors in the (nested) multierror.
+func flattenErrors(err error) []error {
+	merr, ok := err.(*multierror.Error)
+	if !ok {
+		return []error{err}
+	}
+	errs := make([]error, 0, len(merr.Errors))
+	for _, e := range merr.Errors {
+		errs = append(errs, flattenErrors(e)...)
+	}
+	return errs
+}
+
 func usage() {
 	fmt.Print(`
 Usage: vain COMMAND ARGS
 
 COMMAND
-  build
+  build [--target={vim8.0|vim8.2}] [--diagnostics={text|json|quickfix}] [--max-errors=N] [FILE or DIR ...]
     Transpile .vain files under current directory
+
+    --target
+      Vim version which runs the output (default: vim8.0)
+
+    --diagnostics
+      Output format of errors (default: text)
+      text:     the messages with the source code
+      json:     a JSON object per line, which has "file", "line", "col",
+                "end" ({"line", "col"} after the code, or null), "severity",
+                "rule", "message", and "notes"
+      quickfix: "file:line:col: severity: message" lines for Vim's :cfile
+
+    --max-errors
+      Maximum number of errors to print (default: 0, unlimited)
+
+  fmt [--max-errors=N] [FILE or DIR ...]
+    Format .vain files under current directory (output: FILE.pretty)
+
+    --max-errors
+      Maximum number of errors to print (default: 0, unlimited)
 `)
 }
 
 func cmdBuild(args []string) error {
+	fs := flag.NewFlagSet("build", flag.ContinueOnError)
+	targetName := fs.String("target", "vim8.0", "Vim version which runs the output")
+	formatName := fs.String("diagnostics", "text", "Output format of errors")
+	maxErrors := fs.Int("max-errors", 0, "Maximum number of errors to print (0 is unlimited)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	args = fs.Args()
+	target, err := parseVimTarget(*targetName)
+	if err != nil {
+		return err
+	}
+	format, err := parseDiagnosticsFormat(*formatName)
+	if err != nil {
+		return err
+	}
+
 	buildErrs := make(chan error, 16)
 	errs := make([]error, 0, 16)
 	done := make(chan bool, 1)
@@ -57,11 +248,12 @@ func cmdBuild(args []string) error {
 	if err != nil {
 		fmt.Printf("warning: could not read standard library: %s\n", err.Error())
 	}
+	resolver := newResolver(".", getVainRoot(), stdlib)
 
 	// 3. Collect errors
 	go func() {
 		for err := range buildErrs {
-			errs = append(errs, err)
+			errs = append(errs, flattenErrors(err)...)
 		}
 		done <- true
 	}()
@@ -75,7 +267,7 @@ func cmdBuild(args []string) error {
 		for file := range files {
 			wg.Add(1)
 			go func(file string) {
-				if err := buildFile(file, stdlib); err != nil {
+				if err := buildFile(file, resolver, target); err != nil {
 					buildErrs <- err
 				}
 				wg.Done()
@@ -98,7 +290,15 @@ func cmdBuild(args []string) error {
 	close(buildErrs)
 	<-done
 
-	return multierror.Append(nil, errs...).ErrorOrNil()
+	if len(errs) > 0 {
+		reportErrors(os.Stderr, errs, format, *maxErrors)
+		for _, e := range errs {
+			if !isWarning(e) {
+				return errReported
+			}
+		}
+	}
+	return nil
 }
 
 // collectTargetFiles collects .vain files under current directory.
@@ -125,54 +325,53 @@ func collectTargetFiles(files []string, out chan<- string) error {
 	return nil
 }
 
-func buildFile(name string, stdlib *NamespaceDB) error {
-	src, err := os.Open(name)
+func buildFile(name string, resolver *resolver, target vimTarget) error {
+	// Resolve imported modules.
+	m, nsdb, err := resolver.Resolve(name)
 	if err != nil {
-		return err
+		// The errors may be already reported by another file importing the module.
+		return resolver.unreported(err)
 	}
 
-	var content strings.Builder
-	_, err = io.Copy(&content, src)
-	src.Close()
-	if err != nil {
-		return err
+	// Reuse the nodes parsed by the resolver.
+	// They are cloned because the analyzer modifies them.
+	nodes := make(chan node.Node, len(m.nodes))
+	for _, n := range m.nodes {
+		nodes <- n.Clone()
 	}
+	close(nodes)
 
-	lexer := lex(name, content.String())
-	parser := parse(name, lexer.Tokens(), false)
-	analyzer := analyze(name, parser.Nodes(), ToplevelNamespace)
-	translator := translate(name, analyzer.Nodes())
+	analyzer := analyze(name, nodes, ToplevelNamespace)
+	translator := translate(name, analyzer.Nodes(), target, resolver.Modules(name))
 
 	vimFile := name[:len(name)-len(".vain")] + ".vim"
 	writeErr := make(chan error, 1)
 
-	// 5. []io.Reader -> Write to file.vim
+	// 3. []io.Reader -> Write to file.vim
 	go func() {
 		writeErr <- writeReaders(translator.Readers(), vimFile)
 	}()
 
-	// 4. []node.Node -> Translate to vim script -> []io.Reader
+	// 2. []node.Node -> Translate to vim script -> []io.Reader
 	go translator.Run()
 
-	// 3. []node.Node -> Check semantic errors, emit intermediate code -> []node.Node
-	go analyzer.Run(stdlib)
-
-	// 2. []token -> Parse -> []node.Node
-	go parser.Run()
-
-	// 1. source code -> Lex -> []token
-	go lexer.Run()
+	// 1. []node.Node -> Check semantic errors, emit intermediate code -> []node.Node
+	go analyzer.Run(nsdb)
 
 	return <-writeErr
 }
 
-// Collect .vain files from $VAINROOT/lib .
-func collectStdlibFiles() ([]string, error) {
-	vainroot := "."
+// getVainRoot returns $VAINROOT or current directory if it is not set.
+func getVainRoot() string {
 	if v := os.Getenv("VAINROOT"); v != "" {
-		vainroot = v
+		return v
 	}
-	libDir := filepath.Join(vainroot, "lib")
+	return "."
+}
+
+// Collect .vain files from $VAINROOT/lib .
+func collectStdlibFiles() ([]string, error) {
+	libDir := filepath.Join(getVainRoot(), "lib")
 	if fi, err := os.Stat(libDir); err != nil {
 		return nil, err
 	} else if !fi.IsDir() {
@@ -232,13 +431,13 @@ func loadStdlib() (*NamespaceDB, error) {
 	var wgAnalyze sync.WaitGroup
 	nodes := make(chan node.Node, len(files))
 	errs := make([]error, len(files))
-	analyzer := analyze("<stdlib>", nodes, ToplevelNamespace)
+	analyzer := analyze("<stdlib>", nodes, BuiltinNamespace)
 
 	// 5. Handle analyzer errors.
 	wgAnalyze.Add(1)
 	go func() {
 		for n := range analyzer.Nodes() {
-			if err, ok := n.TerminalNode().(error); ok {
+			if err, ok := n.TerminalNode().(error); ok && !isWarning(err) {
 				errs = append(errs, err)
 			}
 		}
@@ -292,6 +491,8 @@ func loadStdlib() (*NamespaceDB, error) {
 
 // Write given readers to temporary file with a buffer.
 // And after successful write, rename to dst.
+// If the readers return only warnings, the file is written and
+// the warnings are returned.
 func writeReaders(readers <-chan io.Reader, dst string) error {
 	tmpfile, err := ioutil.TempFile("", "vainsrc")
 	if err != nil {
@@ -299,26 +500,49 @@ func writeReaders(readers <-chan io.Reader, dst string) error {
 	}
 	dstbuf := bufio.NewWriter(tmpfile)
 
+	var result *multierror.Error
+	var warnings *multierror.Error
 	for r := range readers {
+		if result != nil {
+			// Don't write the rest, but collect all errors.
+			if _, e := io.Copy(ioutil.Discard, r); e != nil {
+				result = multierror.Append(result, e)
+			}
+			continue
+		}
+		if er, ok := r.(*errorReader); ok && isWarning(er.err) {
+			// Warnings don't stop writing the file.
+			warnings = multierror.Append(warnings, er.err)
+			continue
+		}
 		if _, e := io.Copy(dstbuf, r); e != nil {
-			err = e
-			break
+			result = multierror.Append(result, e)
 		}
 	}
 
-	if err != nil {
+	if result != nil {
 		tmpfile.Close()
 		os.Remove(tmpfile.Name())
-		return err
+		return multierror.Append(warnings, result.Errors...)
 	}
 	if err := dstbuf.Flush(); err != nil {
 		return err
 	}
 	tmpfile.Close()
-	return os.Rename(tmpfile.Name(), dst)
+	if err := os.Rename(tmpfile.Name(), dst); err != nil {
+		return err
+	}
+	return warnings.ErrorOrNil()
 }
 
 func cmdFormat(args []string) error {
+	fs := flag.NewFlagSet("fmt", flag.ContinueOnError)
+	maxErrors := fs.Int("max-errors", 0, "Maximum number of errors to print (0 is unlimited)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	args = fs.Args()
+
 	buildErrs := make(chan error, 16)
 	errs := make([]error, 0, 16)
 	done := make(chan bool, 1)
@@ -326,7 +550,7 @@ func cmdFormat(args []string) error {
 	// 3. Collect errors
 	go func() {
 		for err := range buildErrs {
-			errs = append(errs, err)
+			errs = append(errs, flattenErrors(err)...)
 		}
 		done <- true
 	}()
@@ -363,7 +587,11 @@ func cmdFormat(args []string) error {
 	close(buildErrs)
 	<-done
 
-	return multierror.Append(nil, errs...).ErrorOrNil()
+	if len(errs) > 0 {
+		reportErrors(os.Stderr, errs, diagnosticsText, *maxErrors)
+		return errReported
+	}
+	return nil
 }
 
 func formatFile(name string) error {
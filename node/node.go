@@ -64,6 +64,11 @@ func (n *ErrorNode) Error() string {
 	return n.err.Error()
 }
 
+// Unwrap returns the error.
+func (n *ErrorNode) Unwrap() error {
+	return n.err
+}
+
 // TerminalNode returns itself.
 func (n *ErrorNode) TerminalNode() Node {
 	return n
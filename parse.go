@@ -1,8 +1,10 @@
 package main
 
 import (
-	"errors"
 	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
 
 	"github.com/tyru/vain/node"
 )
@@ -30,6 +32,8 @@ type parser struct {
 	token       *token  // next() sets read token to this.
 	nextTokens  []token // next() doesn't read from inTokens if len(nextTokens) > 0 .
 	saveEnvs    []saveEnv
+	errs        []*node.ErrorNode // syntax errors recovered by recoverError()
+	lexFailed   bool              // true if the lexer stopped by an error
 }
 
 type saveEnv struct {
@@ -45,19 +49,24 @@ type statement interface {
 	node.Node
 }
 
+// Run parses the tokens until EOF, and sends the result to Nodes().
+// The result is the following nodes in this order:
+//
+// 1. *node.ErrorNode for each syntax error (zero or more).
+//    Each error has the position where the error occurred.
+// 2. *topLevelNode (exactly one).
+//    If any syntax error was sent, topLevelNode.partial is true and
+//    it contains only the statements parsed successfully.
+//
+// Then Nodes() is closed.
+// The consumers must not handle the partial node as the whole file
+// (analyzer.Run() does not analyze it, formatter.Run() does not format it).
 func (p *parser) Run() {
-Loop:
-	for {
-		switch toplevel, err := p.acceptTopLevel(); {
-		case err == errParseEOF:
-			p.emit(toplevel)
-			break Loop
-		case err == errParseEOF:
-			p.emit(err)
-		case err == errParseEOF:
-			p.emit(toplevel)
-		}
+	toplevel := p.acceptTopLevel()
+	for _, err := range p.errs {
+		p.emit(err)
 	}
+	p.emit(toplevel)
 	close(p.outNodes) // No more nodes will be delivered.
 }
 
@@ -67,6 +76,69 @@ func (p *parser) drain() {
 	}
 }
 
+// synchronize skips tokens after a syntax error until one of
+// the following synchronization points:
+// * newline at the statement level (it is consumed)
+// * "}" which closes the current block (it is not consumed)
+// * EOF or lexer error (it is not consumed)
+// Brackets opened after the error are skipped with their contents,
+// and unmatched ")" and "]" are skipped.
+// If inBlock is false, unmatched "}" is also skipped.
+func (p *parser) synchronize(inBlock bool) {
+	depth := 0
+	for {
+		switch t := p.next(); t.typ {
+		case tokenEOF:
+			return
+		case tokenError:
+			p.backup()
+			return
+		case tokenNewline:
+			if depth == 0 {
+				return
+			}
+		case tokenCOpen, tokenPOpen, tokenSqOpen:
+			depth++
+		case tokenCClose:
+			if depth == 0 && inBlock {
+				p.backup()
+				return
+			}
+			if depth > 0 {
+				depth--
+			}
+		case tokenPClose, tokenSqClose:
+			if depth > 0 {
+				depth--
+			}
+		}
+	}
+}
+
+// recoverError records the syntax error and skips to the next statement.
+// It returns false if the parser is trying an alternative (see save()),
+// because the error may be discarded by restore().
+func (p *parser) recoverError(err *node.ErrorNode, inBlock bool) bool {
+	if len(p.saveEnvs) > 0 {
+		return false
+	}
+	if p.token.typ != tokenError && p.peek().typ == tokenError {
+		p.next()
+	}
+	switch {
+	case p.lexFailed:
+		// The rest of the input was not tokenized, so the error is meaningless.
+	case p.token.typ == tokenError:
+		// The syntax error was caused by the lexer error.
+		p.errs = append(p.errs, p.lexError())
+		p.lexFailed = true
+	default:
+		p.errs = append(p.errs, err)
+	}
+	p.synchronize(inBlock)
+	return true
+}
+
 // emit passes an node back to the client.
 func (p *parser) emit(node node.Node) {
 	p.outNodes <- node
@@ -74,23 +146,20 @@ func (p *parser) emit(node node.Node) {
 
 // errorf returns an error token and terminates the scan node.
 func (p *parser) errorf(format string, args ...interface{}) *node.ErrorNode {
-	newargs := make([]interface{}, 0, len(args)+2)
-	newargs = append(newargs, p.name, p.token.pos.Line(), p.token.pos.Col()+1)
-	newargs = append(newargs, args...)
-	err := fmt.Errorf("[parse] %s:%d:%d: "+format, newargs...)
+	err := newDiagnostic("parse", p.name, p.token.pos, p.token.end(), fmt.Sprintf(format, args...))
 	return node.NewErrorNode(err, p.token.pos)
 }
 
 // lexError returns an lex error node.
 // It is called when tokenError was given.
 func (p *parser) lexError() *node.ErrorNode {
-	return node.NewErrorNode(errors.New(p.token.val), p.token.pos)
+	err := newDiagnostic("lex", p.name, p.token.pos, nil, p.token.val)
+	return node.NewErrorNode(err, p.token.pos)
 }
 
 // declareOnlyError returns an declare only error node.
 func (p *parser) declareOnlyError(pos *node.Pos) *node.ErrorNode {
-	err := fmt.Errorf("[parse] %s:%d:%d: this file is declaration only",
-		p.name, pos.Line(), pos.Col()+1)
+	err := newDiagnostic("parse", p.name, pos, nil, "this file is declaration only")
 	return node.NewErrorNode(err, pos)
 }
 
@@ -102,11 +171,22 @@ func (p *parser) next() *token {
 		t = p.nextTokens[len(p.nextTokens)-1]
 		p.nextTokens = p.nextTokens[:len(p.nextTokens)-1]
 	} else {
-		t = <-p.inTokens
+		var ok bool
+		t, ok = <-p.inTokens
+		if !ok {
+			// The lexer stopped without tokenEOF (after tokenError).
+			pos := node.NewPos(0, 1, 0)
+			if p.token != nil {
+				pos = p.token.pos
+			}
+			t = token{tokenEOF, pos, ""}
+		}
 	}
 	p.token = &t
 	if t.typ == tokenEOF {
-		p.backup()
+		// EOF is always left for the next next().
+		// Don't use p.backup() here, EOF is not in saveEnv.
+		p.nextTokens = append(p.nextTokens, t)
 	} else if len(p.saveEnvs) > 0 {
 		env := &p.saveEnvs[len(p.saveEnvs)-1]
 		env.prevTokens = append(env.prevTokens, t)
@@ -126,6 +206,9 @@ func (p *parser) unshift(t *token) {
 }
 
 func (p *parser) backup() {
+	if p.token.typ == tokenEOF {
+		return // next() already left EOF
+	}
 	if len(p.saveEnvs) > 0 {
 		env := &p.saveEnvs[len(p.saveEnvs)-1]
 		if len(env.prevTokens) > 0 {
@@ -140,7 +223,14 @@ func (p *parser) save() {
 }
 
 func (p *parser) forget() {
+	env := p.saveEnvs[len(p.saveEnvs)-1]
 	p.saveEnvs = p.saveEnvs[:len(p.saveEnvs)-1]
+	// The consumed tokens must be restored by restore() of the outer env.
+	if len(p.saveEnvs) > 0 {
+		outer := &p.saveEnvs[len(p.saveEnvs)-1]
+		outer.prevTokens = append(outer.prevTokens, env.prevTokens...)
+		outer.unshifted += env.unshifted
+	}
 }
 
 func (p *parser) restore() {
@@ -239,7 +329,8 @@ func (p *parser) canBeIdentifier(t *token) bool {
 }
 
 type topLevelNode struct {
-	body []node.Node
+	body    []node.Node
+	partial bool // If true, some statements were skipped due to syntax errors.
 }
 
 // Clone clones itself.
@@ -248,7 +339,7 @@ func (n *topLevelNode) Clone() node.Node {
 	for i := range n.body {
 		body[i] = n.body[i].Clone()
 	}
-	return &topLevelNode{body}
+	return &topLevelNode{body, n.partial}
 }
 
 func (n *topLevelNode) TerminalNode() node.Node {
@@ -263,16 +354,28 @@ func (n *topLevelNode) IsExpr() bool {
 	return false
 }
 
-func (p *parser) acceptTopLevel() (*node.PosNode, *node.ErrorNode) {
+// acceptTopLevel accepts statements until EOF.
+// When a statement has a syntax error, the error is recorded to p.errs
+// and the parser continues from the next statement.
+// Then the returned node contains only the statements parsed successfully.
+func (p *parser) acceptTopLevel() *node.PosNode {
 	pos := node.NewPos(0, 1, 0)
-	toplevel := &topLevelNode{make([]node.Node, 0, 32)}
+	toplevel := &topLevelNode{make([]node.Node, 0, 32), false}
 	for {
+		p.acceptSpaces()
+		if p.accept(tokenEOF) {
+			break
+		}
 		n, err := p.acceptStmtOrExpr()
 		if err != nil {
-			return node.NewPosNode(pos, toplevel), err
+			p.saveEnvs = p.saveEnvs[:0] // no alternatives at top level
+			p.recoverError(err, false)
+			continue
 		}
 		toplevel.body = append(toplevel.body, n)
 	}
+	toplevel.partial = len(p.errs) > 0
+	return node.NewPosNode(pos, toplevel)
 }
 
 type commentNode struct {
@@ -300,16 +403,10 @@ func (n *commentNode) Value() string {
 	return n.value[1:]
 }
 
-var errParseEOF = node.NewErrorNode(errors.New("EOF"), nil) // successful EOF
-
 // statementOrExpression := *LF ( comment | statement | expr )
 func (p *parser) acceptStmtOrExpr() (node.Node, *node.ErrorNode) {
 	p.acceptSpaces()
-	if p.accept(tokenEOF) {
-		return nil, errParseEOF
-	}
 	if p.accept(tokenError) {
-		p.drain()
 		return nil, p.lexError()
 	}
 
@@ -335,10 +432,39 @@ func (p *parser) acceptStmtOrExpr() (node.Node, *node.ErrorNode) {
 		return p.acceptWhileStatement()
 	case tokenFor:
 		return p.acceptForStatement()
+	case tokenTry:
+		return p.acceptTryStatement()
+	case tokenThrow:
+		return p.acceptThrowStatement()
+	case tokenBreak:
+		return p.acceptBreakStatement()
+	case tokenContinue:
+		return p.acceptContinueStatement()
+	case tokenVim:
+		return p.acceptVimBlockStatement()
 	case tokenImport:
 		fallthrough
 	case tokenFrom:
 		return p.acceptImportStatement()
+	case tokenIdentifier:
+		switch {
+		case p.isExecuteStatement():
+			return p.acceptExecuteStatement()
+		case p.isExCommand("map"):
+			return p.acceptMapStatement()
+		case p.isExCommand("command"):
+			return p.acceptCommandStatement()
+		case p.isExCommand("autocmd"):
+			return p.acceptAutocmdStatement()
+		case p.isExCommand("augroup"):
+			return p.acceptAugroupStatement()
+		case p.isTypeDeclareStatement():
+			return p.acceptTypeDeclareStatement()
+		case p.isEnumStatement():
+			return p.acceptEnumStatement()
+		case p.isMatchStatement():
+			return p.acceptMatchStatement()
+		}
 	}
 
 	// Expression
@@ -452,13 +578,80 @@ func (p *parser) acceptAssignExpr() (node.Node, *node.ErrorNode) {
 	return n, nil
 }
 
-// assignLhs := identifier | destructuringAssignment
+type compoundAssignExpr struct {
+	op    string // "+=", "-=", "*=", "/=", "%=", "..="
+	left  expr
+	right expr
+}
+
+// Clone clones itself.
+func (n *compoundAssignExpr) Clone() node.Node {
+	return &compoundAssignExpr{n.op, n.left.Clone(), n.right.Clone()}
+}
+
+func (n *compoundAssignExpr) TerminalNode() node.Node {
+	return n
+}
+
+func (n *compoundAssignExpr) Position() *node.Pos {
+	return nil
+}
+
+func (n *compoundAssignExpr) IsExpr() bool {
+	return true
+}
+
+func (n *compoundAssignExpr) Left() node.Node {
+	return n.left
+}
+
+func (n *compoundAssignExpr) Right() expr {
+	return n.right
+}
+
+func (n *compoundAssignExpr) GetLeftIdentifiers() []node.Node {
+	return getLeftIdentifiers(n)
+}
+
+// compoundAssignExpr := identifier compoundAssignOp expr
+// compoundAssignOp := "+=" | "-=" | "*=" | "/=" | "%=" | "..="
+func (p *parser) acceptCompoundAssignExpr() (node.Node, *node.ErrorNode) {
+	if !p.accept(tokenIdentifier) {
+		return nil, p.errorf("expected %s but got %s", tokenName(tokenIdentifier), tokenName(p.peek().typ))
+	}
+	left := node.NewPosNode(p.token.pos, &identifierNode{p.token.val, true})
+	switch p.peek().typ {
+	case tokenPlusEqual, tokenMinusEqual, tokenStarEqual,
+		tokenSlashEqual, tokenPercentEqual, tokenDotDotEqual:
+		p.next()
+	default:
+		return nil, p.errorf("expected compound assignment operator but got %s", tokenName(p.peek().typ))
+	}
+	op := p.token.val
+	right, err := p.acceptExpr()
+	if err != nil {
+		return nil, err
+	}
+	return node.NewPosNode(left.Position(), &compoundAssignExpr{op, left, right}), nil
+}
+
+// assignLhs := identifier | destructuringAssignment | dictDestructuringAssignment
 func (p *parser) acceptAssignLHS() (node.Node, *node.ErrorNode) {
 	var left node.Node
 	if p.accept(tokenIdentifier) {
 		left = node.NewPosNode(p.token.pos, &identifierNode{p.token.val, true})
-	} else if ids, listpos, err := p.acceptDestructuringAssignment(); err == nil {
-		left = node.NewPosNode(listpos, &listNode{ids})
+	} else if p.peek().typ == tokenCOpen {
+		dict, err := p.acceptDictDestructuringAssignment()
+		if err != nil {
+			return nil, err
+		}
+		left = dict
+	} else if p.peek().typ == tokenSqOpen {
+		list, err := p.acceptDestructuringAssignment()
+		if err != nil {
+			return nil, err
+		}
+		left = list
 	} else {
 		return nil, p.errorf(
 			"expected %s or destructuring assignment but got %s",
@@ -469,42 +662,157 @@ func (p *parser) acceptAssignLHS() (node.Node, *node.ErrorNode) {
 	return left, nil
 }
 
-// destructuringAssignment := "[" *blank
-//                            *( identifierOrUnderscore *blank "," )
-//                            identifierOrUnderscore *blank [ "," ]
-//                          *blank "]"
+// destructuringAssignment := listPattern
+// The elements are identifierOrUnderscore.
+func (p *parser) acceptDestructuringAssignment() (node.Node, *node.ErrorNode) {
+	list, err := p.acceptListPattern(p.acceptIdentifierOrUnderscore)
+	if err != nil {
+		return nil, err
+	}
+	if len(list.TerminalNode().(*listNode).value) == 0 {
+		return nil, p.errorf("at least 1 identifier is needed")
+	}
+	return list, nil
+}
+
+// dictDestructuringAssignment := dictPattern
+// The values are identifier.
+//
+// The left-hand side is *dictionaryNode whose keys and values are *identifierNode.
+func (p *parser) acceptDictDestructuringAssignment() (node.Node, *node.ErrorNode) {
+	dict, err := p.acceptDictPattern(p.acceptIdentifier)
+	if err != nil {
+		return nil, err
+	}
+	if len(dict.TerminalNode().(*dictionaryNode).value) == 0 {
+		return nil, p.errorf("at least 1 identifier is needed")
+	}
+	return dict, nil
+}
+
 // identifierOrUnderscore := identifier | "_"
-func (p *parser) acceptDestructuringAssignment() ([]expr, *node.Pos, *node.ErrorNode) {
+func (p *parser) acceptIdentifierOrUnderscore() (node.Node, *node.ErrorNode) {
+	if !p.accept(tokenIdentifier) && !p.accept(tokenUnderscore) {
+		return nil, p.errorf(
+			"expected %s or %s but got %s",
+			tokenName(tokenIdentifier),
+			tokenName(tokenUnderscore),
+			tokenName(p.peek().typ),
+		)
+	}
+	return node.NewPosNode(p.token.pos, &identifierNode{p.token.val, true}), nil
+}
+
+func (p *parser) acceptIdentifier() (node.Node, *node.ErrorNode) {
+	if !p.accept(tokenIdentifier) {
+		return nil, p.errorf(
+			"expected %s but got %s", tokenName(tokenIdentifier), tokenName(p.peek().typ),
+		)
+	}
+	return node.NewPosNode(p.token.pos, &identifierNode{p.token.val, true}), nil
+}
+
+// listPattern := "[" *blank
+//                [ elem *( *blank "," *blank elem ) *blank
+//                  [ "," *blank "..." identifierOrUnderscore *blank ] [ "," *blank ] ]
+//              "]"
+//
+// Each elem is parsed by acceptElem.
+// If the rest field of *listNode is true, the last element is the rest element
+// which is bound to the list of the remaining elements.
+func (p *parser) acceptListPattern(acceptElem func() (node.Node, *node.ErrorNode)) (node.Node, *node.ErrorNode) {
 	if !p.accept(tokenSqOpen) {
-		return nil, nil, p.errorf(
-			"expected %s but got %s", tokenName(tokenLt), tokenName(p.peek().typ),
+		return nil, p.errorf(
+			"expected %s but got %s", tokenName(tokenSqOpen), tokenName(p.peek().typ),
 		)
 	}
 	pos := p.token.pos
 	p.acceptBlanks()
-	if p.accept(tokenSqClose) {
-		return nil, nil, p.errorf("at least 1 identifier is needed")
-	}
-
-	ids := make([]expr, 0, 8)
-	for {
-		if !p.accept(tokenIdentifier) && !p.accept(tokenUnderscore) {
-			return nil, nil, p.errorf(
-				"expected %s or %s but got %s",
-				tokenName(tokenIdentifier),
-				tokenName(tokenUnderscore),
+	list := &listNode{make([]expr, 0, 8), false}
+	for !p.accept(tokenSqClose) {
+		if list.rest {
+			return nil, p.errorf(
+				"expected %s after rest element but got %s",
+				tokenName(tokenSqClose),
 				tokenName(p.peek().typ),
 			)
 		}
-		ids = append(ids, node.NewPosNode(p.token.pos, &identifierNode{p.token.val, true}))
+		if p.accept(tokenDotDotDot) {
+			if len(list.value) == 0 {
+				return nil, p.errorf("at least 1 identifier is needed before rest element")
+			}
+			list.rest = true
+			elem, err := p.acceptIdentifierOrUnderscore()
+			if err != nil {
+				return nil, err
+			}
+			list.value = append(list.value, elem)
+		} else {
+			elem, err := acceptElem()
+			if err != nil {
+				return nil, err
+			}
+			list.value = append(list.value, elem)
+		}
 		p.acceptBlanks()
-		p.accept(tokenComma)
+		if p.accept(tokenComma) {
+			p.acceptBlanks()
+		} else if p.peek().typ != tokenSqClose {
+			return nil, p.errorf(
+				"expected %s or %s but got %s",
+				tokenName(tokenComma), tokenName(tokenSqClose), tokenName(p.peek().typ),
+			)
+		}
+	}
+	return node.NewPosNode(pos, list), nil
+}
+
+// dictPattern := "{" *blank
+//                [ dictPatternEntry *( *blank "," *blank dictPatternEntry ) *blank [ "," *blank ] ]
+//              "}"
+// dictPatternEntry := identifier [ *blank ":" *blank value ]
+//
+// Each value is parsed by acceptValue.
+// The keys of *dictionaryNode are *identifierNode.
+// "{name}" is the shorthand of "{name: name}".
+func (p *parser) acceptDictPattern(acceptValue func() (node.Node, *node.ErrorNode)) (node.Node, *node.ErrorNode) {
+	if !p.accept(tokenCOpen) {
+		return nil, p.errorf(
+			"expected %s but got %s", tokenName(tokenCOpen), tokenName(p.peek().typ),
+		)
+	}
+	pos := p.token.pos
+	p.acceptBlanks()
+	m := make([][]expr, 0, 8)
+	for !p.accept(tokenCClose) {
+		if !p.accept(tokenIdentifier) {
+			return nil, p.errorf(
+				"expected %s but got %s", tokenName(tokenIdentifier), tokenName(p.peek().typ),
+			)
+		}
+		key := node.NewPosNode(p.token.pos, &identifierNode{p.token.val, false})
+		var value node.Node = node.NewPosNode(p.token.pos, &identifierNode{p.token.val, true})
 		p.acceptBlanks()
-		if p.accept(tokenSqClose) {
-			break
+		if p.accept(tokenColon) {
+			p.acceptBlanks()
+			var err *node.ErrorNode
+			value, err = acceptValue()
+			if err != nil {
+				return nil, err
+			}
+			p.acceptBlanks()
+		}
+		m = append(m, []expr{key, value})
+		if p.accept(tokenComma) {
+			p.acceptBlanks()
+		} else if p.peek().typ != tokenCClose {
+			return nil, p.errorf(
+				"expected %s or %s but got %s",
+				tokenName(tokenComma), tokenName(tokenCClose), tokenName(p.peek().typ),
+			)
 		}
 	}
-	return ids, pos, nil
+	return node.NewPosNode(pos, &dictionaryNode{m}), nil
 }
 
 type assignNode interface {
@@ -523,6 +831,12 @@ func getLeftIdentifiers(n assignNode) []node.Node {
 			}
 		}
 		return ids
+	case *dictionaryNode: // Destructuring
+		ids := make([]node.Node, 0, len(left.value))
+		for i := range left.value {
+			ids = append(ids, left.value[i][1])
+		}
+		return ids
 	case *identifierNode:
 		return []node.Node{n.Left()}
 	default:
@@ -727,108 +1041,968 @@ func (p *parser) acceptReturnStatement() (node.Node, *node.ErrorNode) {
 	return node.NewPosNode(ret.pos, &returnStatement{expr}), nil
 }
 
-type ifStatement struct {
-	cond expr
-	body []node.Node
-	els  []node.Node
+type matchStatement struct {
+	left expr
+	arms []matchArm
+}
+
+// matchArm is "pattern -> body" of matchStatement.
+// pattern is one of the following nodes:
+// * *identifierNode: binds the value to the variable ("_" matches any value)
+// * *intNode, *floatNode, *stringNode, *boolNode, *noneNode: literal
+// * *dotNode: the variant of enum (e.g. Mode.Normal)
+// * *listNode: the list whose elements match the patterns
+//   (the last element is the rest element if rest is true)
+// * *dictionaryNode: the dictionary whose values of the keys match the patterns
+type matchArm struct {
+	pattern node.Node
+	body    []node.Node
 }
 
 // Clone clones itself.
-func (n *ifStatement) Clone() node.Node {
-	body := make([]node.Node, len(n.body))
-	for i := range n.body {
-		body[i] = n.body[i].Clone()
-	}
-	els := make([]node.Node, len(n.els))
-	for i := range n.els {
-		els[i] = n.els[i].Clone()
-	}
-	return &ifStatement{
-		n.cond.Clone(), body, els,
+func (n *matchStatement) Clone() node.Node {
+	arms := make([]matchArm, len(n.arms))
+	for i := range n.arms {
+		body := make([]node.Node, len(n.arms[i].body))
+		for j := range n.arms[i].body {
+			body[j] = n.arms[i].body[j].Clone()
+		}
+		arms[i] = matchArm{n.arms[i].pattern.Clone(), body}
 	}
+	return &matchStatement{n.left.Clone(), arms}
 }
 
-func (n *ifStatement) TerminalNode() node.Node {
+func (n *matchStatement) TerminalNode() node.Node {
 	return n
 }
 
-func (n *ifStatement) Position() *node.Pos {
+func (n *matchStatement) Position() *node.Pos {
 	return nil
 }
 
-func (n *ifStatement) IsExpr() bool {
+func (n *matchStatement) IsExpr() bool {
 	return false
 }
 
-// ifStatement := "if" *blank expr *blank block
-//                [ *blank "else" *blank ( ifStatement | block ) ]
-func (p *parser) acceptIfStatement() (node.Node, *node.ErrorNode) {
+// getPatternIdentifiers returns the variables bound by pattern.
+// "_" is not included because it does not bind the value.
+func getPatternIdentifiers(pattern node.Node) []node.Node {
+	switch p := pattern.TerminalNode().(type) {
+	case *identifierNode:
+		if p.value != "_" {
+			return []node.Node{pattern}
+		}
+	case *listNode:
+		ids := make([]node.Node, 0, len(p.value))
+		for i := range p.value {
+			ids = append(ids, getPatternIdentifiers(p.value[i])...)
+		}
+		return ids
+	case *dictionaryNode:
+		ids := make([]node.Node, 0, len(p.value))
+		for i := range p.value {
+			ids = append(ids, getPatternIdentifiers(p.value[i][1])...)
+		}
+		return ids
+	}
+	return nil
+}
+
+// isMatchStatement returns true if the next tokens are "match" and a value.
+// "match(...)" is a function call.
+func (p *parser) isMatchStatement() bool {
+	return p.isContextualKeyword(
+		"match",
+		tokenIdentifier, tokenSqOpen, tokenInt, tokenFloat, tokenString, tokenBool, tokenNone,
+	)
+}
+
+// matchStatement := "match" expr "{" *blank
+//                     *( matchArm *blank [ "," ] *blank )
+//                   "}"
+// If a match arm has a syntax error, it is skipped and the next arm is parsed.
+func (p *parser) acceptMatchStatement() (node.Node, *node.ErrorNode) {
 	if p.declareOnly {
 		p.drain()
 		return nil, p.declareOnlyError(p.peek().pos)
 	}
-	if !p.accept(tokenIf) {
-		return nil, p.errorf("expected if statement but got %s", tokenName(p.peek().typ))
+	if !p.isMatchStatement() {
+		return nil, p.errorf("expected match statement but got %s", tokenName(p.peek().typ))
 	}
-	p.acceptBlanks()
+	p.next()
 	pos := p.token.pos
-	cond, err := p.acceptExpr()
+	left, err := p.acceptExpr()
 	if err != nil {
 		return nil, err
 	}
-	p.acceptBlanks()
-	body, err := p.acceptBlock()
-	if err != nil {
-		return nil, err
+	if !p.accept(tokenCOpen) {
+		return nil, p.errorf("expected %s but got %s", tokenName(tokenCOpen), tokenName(p.peek().typ))
 	}
-	var els []node.Node
 	p.acceptBlanks()
-	if p.accept(tokenElse) {
-		p.acceptBlanks()
-		if p.accept(tokenIf) {
-			p.backup()
-			ifstmt, err := p.acceptIfStatement()
-			if err != nil {
-				return nil, err
-			}
-			els = []node.Node{ifstmt}
-		} else if p.accept(tokenCOpen) {
-			p.backup()
-			block, err := p.acceptBlock()
-			if err != nil {
+	arms := make([]matchArm, 0, 8)
+	skipped := false
+	for !p.accept(tokenCClose) {
+		if p.accept(tokenEOF) {
+			return nil, p.errorf("expected %s but got %s", tokenName(tokenCClose), tokenName(tokenEOF))
+		}
+		arm, err := p.acceptMatchArm()
+		if err != nil {
+			// Skip to the next arm, or "}" of the match statement.
+			if !p.recoverError(err, true) {
 				return nil, err
 			}
-			els = block
-		} else {
-			return nil, p.errorf("expected if or block statement but got %s", tokenName(p.peek().typ))
+			skipped = true
+			p.acceptBlanks()
+			continue
+		}
+		arms = append(arms, *arm)
+		p.acceptBlanks()
+		if p.accept(tokenComma) {
+			p.acceptBlanks()
 		}
 	}
-	n := node.NewPosNode(pos, &ifStatement{cond, body, els})
-	return n, nil
-}
-
-type whileStatement struct {
-	cond expr
-	body []node.Node
+	if len(arms) == 0 && !skipped {
+		return nil, p.errorf("match statement must have at least one arm")
+	}
+	return node.NewPosNode(pos, &matchStatement{left, arms}), nil
 }
 
-// Clone clones itself.
-func (n *whileStatement) Clone() node.Node {
-	body := make([]node.Node, len(n.body))
-	for i := range n.body {
-		body[i] = n.body[i].Clone()
+// matchArm := pattern "->" *blank ( block | statementOrExpression )
+func (p *parser) acceptMatchArm() (*matchArm, *node.ErrorNode) {
+	pattern, err := p.acceptPattern()
+	if err != nil {
+		return nil, err
 	}
-	return &whileStatement{
-		n.cond.Clone(), body,
+	if !p.accept(tokenArrow) {
+		return nil, p.errorf("expected %s but got %s", tokenName(tokenArrow), tokenName(p.peek().typ))
 	}
+	p.acceptBlanks()
+	var body []node.Node
+	if p.peek().typ == tokenCOpen {
+		body, err = p.acceptBlock()
+	} else {
+		var stmt node.Node
+		stmt, err = p.acceptStmtOrExpr()
+		body = []node.Node{stmt}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &matchArm{pattern, body}, nil
 }
 
-func (n *whileStatement) TerminalNode() node.Node {
-	return n
-}
-
-func (n *whileStatement) Position() *node.Pos {
-	return nil
+// pattern := "_" / identifier [ "." identifier ] /
+//            [ "-" ] int / [ "-" ] float / string / bool / none /
+//            listPattern / dictPattern
+// The elements of listPattern and the values of dictPattern are pattern.
+func (p *parser) acceptPattern() (node.Node, *node.ErrorNode) {
+	t := p.next()
+	pos := t.pos
+	switch t.typ {
+	case tokenUnderscore:
+		return node.NewPosNode(pos, &identifierNode{"_", true}), nil
+	case tokenIdentifier:
+		id := node.NewPosNode(pos, &identifierNode{t.val, true})
+		if !p.accept(tokenDot) {
+			return id, nil
+		}
+		dot := p.token
+		if !p.accept(tokenIdentifier) {
+			return nil, p.errorf("expected %s but got %s", tokenName(tokenIdentifier), tokenName(p.peek().typ))
+		}
+		variant := node.NewPosNode(p.token.pos, &identifierNode{p.token.val, false})
+		return node.NewPosNode(dot.pos, &dotNode{id, variant}), nil
+	case tokenMinus:
+		if p.accept(tokenInt) {
+			return node.NewPosNode(p.token.pos, &intNode{"-" + p.token.val}), nil
+		} else if p.accept(tokenFloat) {
+			return node.NewPosNode(p.token.pos, &floatNode{"-" + p.token.val}), nil
+		}
+		return nil, p.errorf("expected %s or %s but got %s",
+			tokenName(tokenInt), tokenName(tokenFloat), tokenName(p.peek().typ))
+	case tokenInt:
+		return node.NewPosNode(pos, &intNode{t.val}), nil
+	case tokenFloat:
+		return node.NewPosNode(pos, &floatNode{t.val}), nil
+	case tokenString:
+		return node.NewPosNode(pos, &stringNode{vainString(t.val)}), nil
+	case tokenBool:
+		return node.NewPosNode(pos, &boolNode{t.val == "true"}), nil
+	case tokenNone:
+		return node.NewPosNode(pos, &noneNode{}), nil
+	case tokenSqOpen:
+		p.backup()
+		return p.acceptListPattern(p.acceptPattern)
+	case tokenCOpen:
+		p.backup()
+		return p.acceptDictPattern(p.acceptPattern)
+	}
+	p.backup()
+	return nil, p.errorf("expected pattern but got %s", tokenName(t.typ))
+}
+
+type breakStatement struct{}
+
+// Clone clones itself.
+func (n *breakStatement) Clone() node.Node {
+	return &breakStatement{}
+}
+
+func (n *breakStatement) TerminalNode() node.Node {
+	return n
+}
+
+func (n *breakStatement) Position() *node.Pos {
+	return nil
+}
+
+func (n *breakStatement) IsExpr() bool {
+	return false
+}
+
+// breakStatement := "break"
+func (p *parser) acceptBreakStatement() (node.Node, *node.ErrorNode) {
+	if p.declareOnly {
+		p.drain()
+		return nil, p.declareOnlyError(p.peek().pos)
+	}
+	if !p.accept(tokenBreak) {
+		return nil, p.errorf("expected %s but got %s", tokenName(tokenBreak), tokenName(p.peek().typ))
+	}
+	return node.NewPosNode(p.token.pos, &breakStatement{}), nil
+}
+
+type continueStatement struct{}
+
+// Clone clones itself.
+func (n *continueStatement) Clone() node.Node {
+	return &continueStatement{}
+}
+
+func (n *continueStatement) TerminalNode() node.Node {
+	return n
+}
+
+func (n *continueStatement) Position() *node.Pos {
+	return nil
+}
+
+func (n *continueStatement) IsExpr() bool {
+	return false
+}
+
+// continueStatement := "continue"
+func (p *parser) acceptContinueStatement() (node.Node, *node.ErrorNode) {
+	if p.declareOnly {
+		p.drain()
+		return nil, p.declareOnlyError(p.peek().pos)
+	}
+	if !p.accept(tokenContinue) {
+		return nil, p.errorf("expected %s but got %s", tokenName(tokenContinue), tokenName(p.peek().typ))
+	}
+	return node.NewPosNode(p.token.pos, &continueStatement{}), nil
+}
+
+type vimBlockStatement struct {
+	lines []string
+}
+
+// Clone clones itself.
+func (n *vimBlockStatement) Clone() node.Node {
+	lines := make([]string, len(n.lines))
+	copy(lines, n.lines)
+	return &vimBlockStatement{lines}
+}
+
+func (n *vimBlockStatement) TerminalNode() node.Node {
+	return n
+}
+
+func (n *vimBlockStatement) Position() *node.Pos {
+	return nil
+}
+
+func (n *vimBlockStatement) IsExpr() bool {
+	return false
+}
+
+// vimBlockStatement := "vim" *blank "{" LF *( vimScriptLine LF ) *blank "}"
+// The lines are output verbatim (only indentation is changed).
+func (p *parser) acceptVimBlockStatement() (node.Node, *node.ErrorNode) {
+	if p.declareOnly {
+		p.drain()
+		return nil, p.declareOnlyError(p.peek().pos)
+	}
+	if !p.accept(tokenVim) {
+		return nil, p.errorf("expected %s but got %s", tokenName(tokenVim), tokenName(p.peek().typ))
+	}
+	pos := p.token.pos
+	if !p.accept(tokenVimBlock) {
+		return nil, p.errorf("expected %s but got %s", tokenName(tokenVimBlock), tokenName(p.peek().typ))
+	}
+	lines := strings.Split(strings.TrimSuffix(p.token.val, "\n"), "\n")
+	if p.token.val == "" {
+		lines = nil
+	}
+	return node.NewPosNode(pos, &vimBlockStatement{dedentLines(lines)}), nil
+}
+
+// dedentLines removes trailing spaces and common leading spaces of lines.
+func dedentLines(lines []string) []string {
+	indent := -1
+	for i := range lines {
+		lines[i] = strings.TrimRight(lines[i], " \t\r")
+		if lines[i] == "" {
+			continue
+		}
+		n := len(lines[i]) - len(strings.TrimLeft(lines[i], " \t"))
+		if indent < 0 || n < indent {
+			indent = n
+		}
+	}
+	for i := range lines {
+		if lines[i] != "" {
+			lines[i] = lines[i][indent:]
+		}
+	}
+	return lines
+}
+
+type executeStatement struct {
+	left expr
+}
+
+// Clone clones itself.
+func (n *executeStatement) Clone() node.Node {
+	return &executeStatement{n.left.Clone()}
+}
+
+func (n *executeStatement) TerminalNode() node.Node {
+	return n
+}
+
+func (n *executeStatement) Position() *node.Pos {
+	return nil
+}
+
+func (n *executeStatement) IsExpr() bool {
+	return false
+}
+
+// isExecuteStatement returns true if the next tokens are executeStatement.
+// "execute" is not a keyword because it is also a function name
+// (e.g. execute() in "$vim/ex").
+// It is a statement only if a string or an identifier follows.
+func (p *parser) isExecuteStatement() bool {
+	return p.isContextualKeyword("execute", tokenString, tokenIdentifier)
+}
+
+// executeStatement := "execute" ( templateString | expr )
+// templateString is a string literal which ends the statement.
+// The values of "${expr}" are escaped by fnameescape(),
+// so that they cannot end the command with "|" or start a comment with "\"".
+// The values of "$!{expr}" are inserted as they are (e.g. "nmap $!{lhs} ...").
+func (p *parser) acceptExecuteStatement() (node.Node, *node.ErrorNode) {
+	if p.declareOnly {
+		p.drain()
+		return nil, p.declareOnlyError(p.peek().pos)
+	}
+	if !p.isExecuteStatement() {
+		return nil, p.errorf("expected execute statement but got %s", tokenName(p.peek().typ))
+	}
+	p.next()
+	pos := p.token.pos
+	p.save()
+	if p.accept(tokenString) {
+		str := *p.token
+		switch p.peek().typ {
+		case tokenNewline, tokenComment, tokenCClose, tokenEOF:
+			p.forget()
+			tmpl, err := p.parseTemplate(&str)
+			if err != nil {
+				return nil, err
+			}
+			return node.NewPosNode(pos, &executeStatement{tmpl}), nil
+		}
+	}
+	p.restore()
+	expr, err := p.acceptExpr()
+	if err != nil {
+		return nil, err
+	}
+	return node.NewPosNode(pos, &executeStatement{expr}), nil
+}
+
+// templateNode is a string literal which has "${expr}".
+// len(lits) == len(exprs) + 1 .
+type templateNode struct {
+	quote string   // "'" or "\""
+	lits  []string // literal parts (not evaluated)
+	exprs []expr
+	raw   []bool // true if exprs[i] is "$!{expr}" (not escaped)
+}
+
+// Clone clones itself.
+func (n *templateNode) Clone() node.Node {
+	lits := make([]string, len(n.lits))
+	copy(lits, n.lits)
+	exprs := make([]expr, len(n.exprs))
+	for i := range n.exprs {
+		exprs[i] = n.exprs[i].Clone()
+	}
+	raw := make([]bool, len(n.raw))
+	copy(raw, n.raw)
+	return &templateNode{n.quote, lits, exprs, raw}
+}
+
+func (n *templateNode) TerminalNode() node.Node {
+	return n
+}
+
+func (n *templateNode) Position() *node.Pos {
+	return nil
+}
+
+func (n *templateNode) IsExpr() bool {
+	return true
+}
+
+// parseTemplate parses "${expr}" and "$!{expr}" in the string token.
+// Each expr is parsed by another parser,
+// but the positions of nodes are the positions in this file.
+func (p *parser) parseTemplate(str *token) (node.Node, *node.ErrorNode) {
+	quote := str.val[:1]
+	body := str.val[1 : len(str.val)-1]
+	// col is the column of body.
+	line, col := str.pos.Line(), str.pos.Col()+1
+
+	lits := make([]string, 0, 4)
+	exprs := make([]expr, 0, 4)
+	raws := make([]bool, 0, 4)
+	for {
+		begin := strings.Index(body, "${")
+		raw := false
+		if i := strings.Index(body, "$!{"); i >= 0 && (begin < 0 || i < begin) {
+			begin, raw = i, true
+		}
+		if begin < 0 {
+			break
+		}
+		open := 2
+		if raw {
+			open = 3
+		}
+		end := begin + open
+		for depth := 1; end < len(body); end++ {
+			if body[end] == '{' {
+				depth++
+			} else if body[end] == '}' {
+				if depth--; depth == 0 {
+					break
+				}
+			}
+		}
+		if end >= len(body) {
+			pos := node.NewPos(str.pos.Offset()+col-str.pos.Col()+begin, line, col+begin)
+			end := node.NewPos(pos.Offset()+open, line, pos.Col()+open)
+			msg := fmt.Sprintf("unterminated %q in string", body[begin:begin+open])
+			err := newDiagnostic("parse", p.name, pos, end, msg)
+			return nil, node.NewErrorNode(err, pos)
+		}
+		src := strings.Repeat(" ", col+begin+open) + body[begin+open:end]
+		e, err := p.parseSubExpr(src, line)
+		if err != nil {
+			return nil, err
+		}
+		lits = append(lits, body[:begin])
+		exprs = append(exprs, e)
+		raws = append(raws, raw)
+		body = body[end+1:]
+		col += end + 1
+	}
+	lits = append(lits, body)
+	if len(exprs) == 0 {
+		return node.NewPosNode(str.pos, &stringNode{vainString(str.val)}), nil
+	}
+	return node.NewPosNode(str.pos, &templateNode{quote, lits, exprs, raws}), nil
+}
+
+// parseSubExpr parses src at the line as one expression.
+func (p *parser) parseSubExpr(src string, line int) (expr, *node.ErrorNode) {
+	lexer := lex(p.name, src)
+	lexer.line = line
+	lexer.startLine = line
+	go lexer.Run()
+	sub := parse(p.name, lexer.Tokens(), false)
+	e, err := sub.acceptExpr()
+	if err == nil && sub.peek().typ != tokenEOF {
+		err = sub.errorf("expected \"}\" but got %s", tokenName(sub.peek().typ))
+	}
+	for range lexer.Tokens() {
+	}
+	return e, err
+}
+
+// isExCommand returns true if the next tokens are the statement of
+// Ex command DSL (map, command, autocmd, augroup).
+// The names of them are not keywords because they are also
+// used as variable or function names (e.g. map()).
+// They are statements only if an identifier or "-" (option) follows.
+func (p *parser) isExCommand(name string) bool {
+	return p.isContextualKeyword(name, tokenIdentifier, tokenMinus)
+}
+
+// isContextualKeyword returns true if the next token is the identifier name
+// and one of follows comes after it.
+func (p *parser) isContextualKeyword(name string, follows ...tokenType) bool {
+	if t := p.peek(); t.typ != tokenIdentifier || t.val != name {
+		return false
+	}
+	p.save()
+	p.next()
+	typ := p.peek().typ
+	p.restore()
+	for i := range follows {
+		if typ == follows[i] {
+			return true
+		}
+	}
+	return false
+}
+
+// exOption is an option of Ex command DSL (e.g. "-silent", "-nargs=1").
+type exOption struct {
+	name  string
+	value string // value as written in source ("" if no value)
+}
+
+// eval returns the value of the option.
+// A string literal is evaluated.
+func (o *exOption) eval() string {
+	if o.value != "" && (o.value[0] == '"' || o.value[0] == '\'') {
+		vs := vainString(o.value)
+		if s, err := vs.eval(); err == nil {
+			return s
+		}
+	}
+	return o.value
+}
+
+func cloneExOptions(opts []exOption) []exOption {
+	result := make([]exOption, len(opts))
+	copy(result, opts)
+	return result
+}
+
+// getExOption returns the option of the name.
+func getExOption(opts []exOption, name string) (*exOption, bool) {
+	for i := range opts {
+		if opts[i].name == name {
+			return &opts[i], true
+		}
+	}
+	return nil, false
+}
+
+// Valid options of each Ex command.
+// The value is true if the option needs a value.
+var (
+	mapOptions     = map[string]bool{"buffer": false, "nowait": false, "silent": false, "expr": false, "unique": false, "remap": false}
+	commandOptions = map[string]bool{"nargs": true, "complete": true, "bang": false, "bar": false, "buffer": false}
+	autocmdOptions = map[string]bool{"nested": false, "once": false}
+)
+
+// exOptions := *( "-" identifier [ "=" exOptionValue ] )
+// exOptionValue := identifier | int | string | "*" | "?" | "+"
+func (p *parser) acceptExOptions(cmd string, valid map[string]bool) ([]exOption, *node.ErrorNode) {
+	opts := make([]exOption, 0, 4)
+	for p.accept(tokenMinus) {
+		if !p.accept(tokenIdentifier) {
+			return nil, p.errorf("expected %s but got %s", tokenName(tokenIdentifier), tokenName(p.peek().typ))
+		}
+		name := p.token.val
+		needsValue, ok := valid[name]
+		if !ok {
+			return nil, p.errorf("unknown option of %s: -%s", cmd, name)
+		}
+		var value string
+		if p.accept(tokenEqual) {
+			if !needsValue {
+				return nil, p.errorf("option -%s of %s takes no value", name, cmd)
+			}
+			switch t := p.next(); t.typ {
+			case tokenIdentifier, tokenInt, tokenString, tokenStar, tokenQuestion, tokenPlus:
+				value = t.val
+			default:
+				return nil, p.errorf("expected option value but got %s", tokenName(t.typ))
+			}
+		} else if needsValue {
+			return nil, p.errorf("option -%s of %s needs a value", name, cmd)
+		}
+		if name == "nargs" && (len(value) != 1 || !strings.Contains("01*?+", value)) {
+			return nil, p.errorf("invalid -nargs value: %s (must be 0, 1, *, ? or +)", value)
+		}
+		opts = append(opts, exOption{name, value})
+	}
+	return opts, nil
+}
+
+// acceptExCallback accepts the function name called by Ex command.
+func (p *parser) acceptExCallback() (node.Node, *node.ErrorNode) {
+	if !p.accept(tokenIdentifier) {
+		return nil, p.errorf("expected function name but got %s", tokenName(p.peek().typ))
+	}
+	return node.NewPosNode(p.token.pos, &identifierNode{p.token.val, true}), nil
+}
+
+type mapStatement struct {
+	modes    string
+	options  []exOption
+	lhs      vainString
+	callback node.Node
+}
+
+// Clone clones itself.
+func (n *mapStatement) Clone() node.Node {
+	return &mapStatement{n.modes, cloneExOptions(n.options), n.lhs, n.callback.Clone()}
+}
+
+func (n *mapStatement) TerminalNode() node.Node {
+	return n
+}
+
+func (n *mapStatement) Position() *node.Pos {
+	return nil
+}
+
+func (n *mapStatement) IsExpr() bool {
+	return false
+}
+
+// mapStatement := "map" mapModes exOptions string identifier
+// mapModes := 1*( "n" | "v" | "x" | "s" | "o" | "i" )
+func (p *parser) acceptMapStatement() (node.Node, *node.ErrorNode) {
+	if p.declareOnly {
+		p.drain()
+		return nil, p.declareOnlyError(p.peek().pos)
+	}
+	if !p.isExCommand("map") {
+		return nil, p.errorf("expected map statement but got %s", tokenName(p.peek().typ))
+	}
+	p.next()
+	pos := p.token.pos
+	if !p.accept(tokenIdentifier) {
+		return nil, p.errorf("expected map modes but got %s", tokenName(p.peek().typ))
+	}
+	modes := p.token.val
+	for _, m := range modes {
+		if !strings.ContainsRune("nvxsoi", m) {
+			return nil, p.errorf("invalid map mode: %c (must be some of n, v, x, s, o, i)", m)
+		}
+	}
+	opts, err := p.acceptExOptions("map", mapOptions)
+	if err != nil {
+		return nil, err
+	}
+	if !p.accept(tokenString) {
+		return nil, p.errorf("expected %s but got %s", tokenName(tokenString), tokenName(p.peek().typ))
+	}
+	lhs := vainString(p.token.val)
+	callback, err := p.acceptExCallback()
+	if err != nil {
+		return nil, err
+	}
+	return node.NewPosNode(pos, &mapStatement{modes, opts, lhs, callback}), nil
+}
+
+type commandStatement struct {
+	name     string
+	options  []exOption
+	callback node.Node
+}
+
+// Clone clones itself.
+func (n *commandStatement) Clone() node.Node {
+	return &commandStatement{n.name, cloneExOptions(n.options), n.callback.Clone()}
+}
+
+func (n *commandStatement) TerminalNode() node.Node {
+	return n
+}
+
+func (n *commandStatement) Position() *node.Pos {
+	return nil
+}
+
+func (n *commandStatement) IsExpr() bool {
+	return false
+}
+
+// nargs returns the value of -nargs option ("0" if not specified).
+func (n *commandStatement) nargs() string {
+	if opt, ok := getExOption(n.options, "nargs"); ok {
+		return opt.value
+	}
+	return "0"
+}
+
+// commandStatement := "command" exOptions identifier identifier
+func (p *parser) acceptCommandStatement() (node.Node, *node.ErrorNode) {
+	if p.declareOnly {
+		p.drain()
+		return nil, p.declareOnlyError(p.peek().pos)
+	}
+	if !p.isExCommand("command") {
+		return nil, p.errorf("expected command statement but got %s", tokenName(p.peek().typ))
+	}
+	p.next()
+	pos := p.token.pos
+	opts, err := p.acceptExOptions("command", commandOptions)
+	if err != nil {
+		return nil, err
+	}
+	if !p.accept(tokenIdentifier) {
+		return nil, p.errorf("expected command name but got %s", tokenName(p.peek().typ))
+	}
+	name := p.token.val
+	if !unicode.IsUpper([]rune(name)[0]) {
+		return nil, p.errorf("command name must start with an uppercase letter: %s", name)
+	}
+	callback, err := p.acceptExCallback()
+	if err != nil {
+		return nil, err
+	}
+	return node.NewPosNode(pos, &commandStatement{name, opts, callback}), nil
+}
+
+type autocmdStatement struct {
+	events   []string
+	pattern  vainString
+	options  []exOption
+	callback node.Node
+}
+
+// Clone clones itself.
+func (n *autocmdStatement) Clone() node.Node {
+	events := make([]string, len(n.events))
+	copy(events, n.events)
+	return &autocmdStatement{events, n.pattern, cloneExOptions(n.options), n.callback.Clone()}
+}
+
+func (n *autocmdStatement) TerminalNode() node.Node {
+	return n
+}
+
+func (n *autocmdStatement) Position() *node.Pos {
+	return nil
+}
+
+func (n *autocmdStatement) IsExpr() bool {
+	return false
+}
+
+// autocmdStatement := "autocmd" exOptions identifier *( "," identifier ) string identifier
+func (p *parser) acceptAutocmdStatement() (node.Node, *node.ErrorNode) {
+	if p.declareOnly {
+		p.drain()
+		return nil, p.declareOnlyError(p.peek().pos)
+	}
+	if !p.isExCommand("autocmd") {
+		return nil, p.errorf("expected autocmd statement but got %s", tokenName(p.peek().typ))
+	}
+	p.next()
+	pos := p.token.pos
+	opts, err := p.acceptExOptions("autocmd", autocmdOptions)
+	if err != nil {
+		return nil, err
+	}
+	events := make([]string, 0, 2)
+	for {
+		if !p.accept(tokenIdentifier) {
+			return nil, p.errorf("expected event name but got %s", tokenName(p.peek().typ))
+		}
+		events = append(events, p.token.val)
+		if !p.accept(tokenComma) {
+			break
+		}
+	}
+	if !p.accept(tokenString) {
+		return nil, p.errorf("expected %s but got %s", tokenName(tokenString), tokenName(p.peek().typ))
+	}
+	pattern := vainString(p.token.val)
+	callback, err := p.acceptExCallback()
+	if err != nil {
+		return nil, err
+	}
+	return node.NewPosNode(pos, &autocmdStatement{events, pattern, opts, callback}), nil
+}
+
+type augroupStatement struct {
+	name string
+	body []node.Node
+}
+
+// Clone clones itself.
+func (n *augroupStatement) Clone() node.Node {
+	body := make([]node.Node, len(n.body))
+	for i := range n.body {
+		body[i] = n.body[i].Clone()
+	}
+	return &augroupStatement{n.name, body}
+}
+
+func (n *augroupStatement) TerminalNode() node.Node {
+	return n
+}
+
+func (n *augroupStatement) Position() *node.Pos {
+	return nil
+}
+
+func (n *augroupStatement) IsExpr() bool {
+	return false
+}
+
+// augroupStatement := "augroup" identifier *blank
+//                     "{" *blank *( autocmdStatement *blank ) "}"
+func (p *parser) acceptAugroupStatement() (node.Node, *node.ErrorNode) {
+	if p.declareOnly {
+		p.drain()
+		return nil, p.declareOnlyError(p.peek().pos)
+	}
+	if !p.isExCommand("augroup") {
+		return nil, p.errorf("expected augroup statement but got %s", tokenName(p.peek().typ))
+	}
+	p.next()
+	pos := p.token.pos
+	if !p.accept(tokenIdentifier) {
+		return nil, p.errorf("expected augroup name but got %s", tokenName(p.peek().typ))
+	}
+	name := p.token.val
+	p.acceptBlanks()
+	if !p.accept(tokenCOpen) {
+		return nil, p.errorf("expected %s but got %s", tokenName(tokenCOpen), tokenName(p.peek().typ))
+	}
+	body := make([]node.Node, 0, 4)
+	for {
+		p.acceptBlanks()
+		if p.accept(tokenCClose) {
+			break
+		}
+		if !p.isExCommand("autocmd") {
+			return nil, p.errorf("expected autocmd statement but got %s", tokenName(p.peek().typ))
+		}
+		n, err := p.acceptAutocmdStatement()
+		if err != nil {
+			return nil, err
+		}
+		body = append(body, n)
+	}
+	return node.NewPosNode(pos, &augroupStatement{name, body}), nil
+}
+
+type ifStatement struct {
+	cond expr
+	body []node.Node
+	els  []node.Node
+}
+
+// Clone clones itself.
+func (n *ifStatement) Clone() node.Node {
+	body := make([]node.Node, len(n.body))
+	for i := range n.body {
+		body[i] = n.body[i].Clone()
+	}
+	els := make([]node.Node, len(n.els))
+	for i := range n.els {
+		els[i] = n.els[i].Clone()
+	}
+	return &ifStatement{
+		n.cond.Clone(), body, els,
+	}
+}
+
+func (n *ifStatement) TerminalNode() node.Node {
+	return n
+}
+
+func (n *ifStatement) Position() *node.Pos {
+	return nil
+}
+
+func (n *ifStatement) IsExpr() bool {
+	return false
+}
+
+// ifStatement := "if" *blank expr *blank block
+//                [ *blank "else" *blank ( ifStatement | block ) ]
+func (p *parser) acceptIfStatement() (node.Node, *node.ErrorNode) {
+	if p.declareOnly {
+		p.drain()
+		return nil, p.declareOnlyError(p.peek().pos)
+	}
+	if !p.accept(tokenIf) {
+		return nil, p.errorf("expected if statement but got %s", tokenName(p.peek().typ))
+	}
+	p.acceptBlanks()
+	pos := p.token.pos
+	cond, err := p.acceptExpr()
+	if err != nil {
+		return nil, err
+	}
+	p.acceptBlanks()
+	body, err := p.acceptBlock()
+	if err != nil {
+		return nil, err
+	}
+	var els []node.Node
+	p.acceptBlanks()
+	if p.accept(tokenElse) {
+		p.acceptBlanks()
+		if p.accept(tokenIf) {
+			p.backup()
+			ifstmt, err := p.acceptIfStatement()
+			if err != nil {
+				return nil, err
+			}
+			els = []node.Node{ifstmt}
+		} else if p.accept(tokenCOpen) {
+			p.backup()
+			block, err := p.acceptBlock()
+			if err != nil {
+				return nil, err
+			}
+			els = block
+		} else {
+			return nil, p.errorf("expected if or block statement but got %s", tokenName(p.peek().typ))
+		}
+	}
+	n := node.NewPosNode(pos, &ifStatement{cond, body, els})
+	return n, nil
+}
+
+type whileStatement struct {
+	cond expr
+	body []node.Node
+}
+
+// Clone clones itself.
+func (n *whileStatement) Clone() node.Node {
+	body := make([]node.Node, len(n.body))
+	for i := range n.body {
+		body[i] = n.body[i].Clone()
+	}
+	return &whileStatement{
+		n.cond.Clone(), body,
+	}
+}
+
+func (n *whileStatement) TerminalNode() node.Node {
+	return n
+}
+
+func (n *whileStatement) Position() *node.Pos {
+	return nil
 }
 
 func (n *whileStatement) IsExpr() bool {
@@ -863,6 +2037,7 @@ type forStatement struct {
 	left  node.Node
 	right expr
 	body  []node.Node
+	pairs bool // If true, the dictionary pattern destructures [key, value] pairs of items().
 }
 
 // Clone clones itself.
@@ -872,7 +2047,7 @@ func (n *forStatement) Clone() node.Node {
 		body[i] = n.body[i].Clone()
 	}
 	return &forStatement{
-		n.left.Clone(), n.right.Clone(), body,
+		n.left.Clone(), n.right.Clone(), body, n.pairs,
 	}
 }
 
@@ -901,6 +2076,9 @@ func (n *forStatement) GetLeftIdentifiers() []node.Node {
 }
 
 // forStatement := "for" *blank assignLhs *blank "in" *blank expr *blank block
+// The dictionary pattern destructures [key, value] pairs only if expr is
+// the call of items() (e.g. "for {key, value} in items(d)").
+// Otherwise it destructures dictionaries (e.g. "for {name, path} in list").
 func (p *parser) acceptForStatement() (node.Node, *node.ErrorNode) {
 	if p.declareOnly {
 		p.drain()
@@ -929,10 +2107,166 @@ func (p *parser) acceptForStatement() (node.Node, *node.ErrorNode) {
 	if err != nil {
 		return nil, err
 	}
-	n := node.NewPosNode(pos, &forStatement{left, right, body})
+	_, pairs := left.TerminalNode().(*dictionaryNode)
+	pairs = pairs && isItemsCall(right)
+	n := node.NewPosNode(pos, &forStatement{left, right, body, pairs})
+	return n, nil
+}
+
+// isItemsCall returns true if n is the call of items() (e.g. "items(d)").
+func isItemsCall(n expr) bool {
+	call, ok := n.TerminalNode().(*callNode)
+	if !ok {
+		return false
+	}
+	id, ok := call.left.TerminalNode().(*identifierNode)
+	return ok && id.isVarname && id.value == "items"
+}
+
+type tryStatement struct {
+	body       []node.Node
+	catches    []catchClause
+	finally    []node.Node
+	hasFinally bool
+}
+
+type catchClause struct {
+	pattern string    // "/pattern/" or empty (catch all exceptions)
+	left    node.Node // the variable of v:exception (nil if not specified)
+	body    []node.Node
+}
+
+// Clone clones itself.
+func (n *tryStatement) Clone() node.Node {
+	body := make([]node.Node, len(n.body))
+	for i := range n.body {
+		body[i] = n.body[i].Clone()
+	}
+	catches := make([]catchClause, len(n.catches))
+	for i := range n.catches {
+		var left node.Node
+		if n.catches[i].left != nil {
+			left = n.catches[i].left.Clone()
+		}
+		cbody := make([]node.Node, len(n.catches[i].body))
+		for j := range n.catches[i].body {
+			cbody[j] = n.catches[i].body[j].Clone()
+		}
+		catches[i] = catchClause{n.catches[i].pattern, left, cbody}
+	}
+	finally := make([]node.Node, len(n.finally))
+	for i := range n.finally {
+		finally[i] = n.finally[i].Clone()
+	}
+	return &tryStatement{body, catches, finally, n.hasFinally}
+}
+
+func (n *tryStatement) TerminalNode() node.Node {
+	return n
+}
+
+func (n *tryStatement) Position() *node.Pos {
+	return nil
+}
+
+func (n *tryStatement) IsExpr() bool {
+	return false
+}
+
+// tryStatement := "try" *blank block
+//                 *( *blank "catch" [ pattern ] [ identifier ] *blank block )
+//                 [ *blank "finally" *blank block ]
+// At least one "catch" or "finally" is needed.
+func (p *parser) acceptTryStatement() (node.Node, *node.ErrorNode) {
+	if p.declareOnly {
+		p.drain()
+		return nil, p.declareOnlyError(p.peek().pos)
+	}
+	if !p.accept(tokenTry) {
+		return nil, p.errorf("expected try statement but got %s", tokenName(p.peek().typ))
+	}
+	pos := p.token.pos
+	p.acceptBlanks()
+	body, err := p.acceptBlock()
+	if err != nil {
+		return nil, err
+	}
+	catches := make([]catchClause, 0, 2)
+	p.acceptBlanks()
+	for p.accept(tokenCatch) {
+		var c catchClause
+		if p.accept(tokenPattern) {
+			c.pattern = p.token.val
+		}
+		if p.accept(tokenIdentifier) {
+			c.left = node.NewPosNode(p.token.pos, &identifierNode{p.token.val, true})
+		}
+		p.acceptBlanks()
+		c.body, err = p.acceptBlock()
+		if err != nil {
+			return nil, err
+		}
+		catches = append(catches, c)
+		p.acceptBlanks()
+	}
+	var finally []node.Node
+	var hasFinally bool
+	if p.accept(tokenFinally) {
+		p.acceptBlanks()
+		finally, err = p.acceptBlock()
+		if err != nil {
+			return nil, err
+		}
+		hasFinally = true
+	}
+	if len(catches) == 0 && !hasFinally {
+		return nil, p.errorf(
+			"expected %s or %s but got %s",
+			tokenName(tokenCatch), tokenName(tokenFinally), tokenName(p.peek().typ),
+		)
+	}
+	n := node.NewPosNode(pos, &tryStatement{body, catches, finally, hasFinally})
 	return n, nil
 }
 
+type throwStatement struct {
+	left expr
+}
+
+// Clone clones itself.
+func (n *throwStatement) Clone() node.Node {
+	return &throwStatement{n.left.Clone()}
+}
+
+func (n *throwStatement) TerminalNode() node.Node {
+	return n
+}
+
+func (n *throwStatement) Position() *node.Pos {
+	return nil
+}
+
+func (n *throwStatement) IsExpr() bool {
+	return false
+}
+
+// throwStatement := "throw" expr
+func (p *parser) acceptThrowStatement() (node.Node, *node.ErrorNode) {
+	if p.declareOnly {
+		p.drain()
+		return nil, p.declareOnlyError(p.peek().pos)
+	}
+	if !p.accept(tokenThrow) {
+		return nil, p.errorf("expected %s but got %s", tokenName(tokenThrow), tokenName(p.peek().typ))
+	}
+	pos := p.token.pos
+	expr, err := p.acceptExpr()
+	if err != nil {
+		return nil, err
+	}
+	return node.NewPosNode(pos, &throwStatement{expr}), nil
+}
+
 // block := "{" *blank *( statementOrExpression *blank ) "}"
 func (p *parser) acceptBlock() ([]node.Node, *node.ErrorNode) {
 	if !p.accept(tokenCOpen) {
@@ -947,12 +2281,22 @@ func (p *parser) acceptBlock() ([]node.Node, *node.ErrorNode) {
 	if !p.accept(tokenCClose) {
 		nodes = make([]node.Node, 0, 16)
 		for {
+			if p.accept(tokenEOF) {
+				return nil, p.errorf(
+					"expected %s but got %s",
+					tokenName(tokenCClose),
+					tokenName(tokenEOF),
+				)
+			}
 			stmt, err := p.acceptStmtOrExpr()
 			if err != nil {
-				return nil, err
+				if !p.recoverError(err, true) {
+					return nil, err
+				}
+			} else {
+				nodes = append(nodes, stmt)
 			}
 			p.acceptBlanks()
-			nodes = append(nodes, stmt)
 			if p.accept(tokenCClose) {
 				break
 			}
@@ -992,6 +2336,36 @@ func (n *importStatement) IsExpr() bool {
 	return false
 }
 
+// isBuiltin returns true if n imports the standard library package.
+func (n *importStatement) isBuiltin() bool {
+	path, err := n.pkg.eval()
+	return err == nil && isBuiltinPkg(path)
+}
+
+// pkgName returns the name to refer to the package.
+// If the alias is not given, it is the last element of the path
+// ("import 'foo/bar'" -> "bar").
+func (n *importStatement) pkgName() string {
+	if n.pkgAlias != "" {
+		return n.pkgAlias
+	}
+	path, err := n.pkg.eval()
+	if err != nil {
+		return ""
+	}
+	return path[strings.LastIndex(path, "/")+1:]
+}
+
+// importedNames returns the pairs of the original function name and
+// the name to refer to it.
+func (n *importStatement) importedNames() [][2]string {
+	names := make([][2]string, 0, len(n.fnlist))
+	for _, pair := range n.fnlist {
+		names = append(names, [2]string{pair[0], pair[len(pair)-1]})
+	}
+	return names
+}
+
 // importStatement := "import" string [ "as" *blank identifier ] |
 //                    "from" string "import" <importFunctionList>
 func (p *parser) acceptImportStatement() (*node.PosNode, *node.ErrorNode) {
@@ -1076,6 +2450,7 @@ type funcStmtOrExpr struct {
 	bodyIsStmt bool
 	body       []node.Node
 	isExpr     bool
+	isArrow    bool // "(a, b) -> expr" form
 }
 
 // Clone clones itself.
@@ -1089,6 +2464,7 @@ func (n *funcStmtOrExpr) Clone() node.Node {
 		n.bodyIsStmt,
 		body,
 		n.isExpr,
+		n.isArrow,
 	}
 }
 
@@ -1134,29 +2510,118 @@ func (p *parser) acceptFunction(isExpr bool) (*node.PosNode, *node.ErrorNode) {
 		if err != nil {
 			return nil, err
 		}
-		body = block
-	} else {
-		expr, err := p.acceptExpr()
-		if err != nil {
-			return nil, err
+		body = block
+	} else {
+		expr, err := p.acceptExpr()
+		if err != nil {
+			return nil, err
+		}
+		body = []node.Node{expr}
+	}
+
+	funcNode := &funcStmtOrExpr{
+		declare.TerminalNode().(*funcDeclareStatement),
+		bodyIsStmt,
+		body,
+		isExpr,
+		false,
+	}
+	return node.NewPosNode(declare.Position(), funcNode), nil
+}
+
+// isArrowLambda returns true if the next tokens are arrowLambda.
+// It does not consume any tokens.
+func (p *parser) isArrowLambda() bool {
+	p.save()
+	_, err := p.acceptArrowLambdaArgs()
+	p.restore()
+	return err == nil
+}
+
+// arrowLambda := arrowLambdaArgs *blank expr1
+func (p *parser) acceptArrowLambda() (*node.PosNode, *node.ErrorNode) {
+	pos := p.peek().pos
+	args, err := p.acceptArrowLambdaArgs()
+	if err != nil {
+		return nil, err
+	}
+	p.acceptBlanks()
+	expr, err := p.acceptExpr1()
+	if err != nil {
+		return nil, err
+	}
+	funcNode := &funcStmtOrExpr{
+		&funcDeclareStatement{nil, "", args, nil},
+		false,
+		[]node.Node{expr},
+		true,
+		true,
+	}
+	return node.NewPosNode(pos, funcNode), nil
+}
+
+// arrowLambdaArgs := "(" *blank
+//                      [ arrowLambdaArg *( *blank "," *blank arrowLambdaArg ) *blank [ "," ] ]
+//                    *blank ")" "->"
+// arrowLambdaArg := identifier [ ":" *blank type / "=" *blank expr ]
+func (p *parser) acceptArrowLambdaArgs() ([]argument, *node.ErrorNode) {
+	if !p.accept(tokenPOpen) {
+		return nil, p.errorf(
+			"expected %s but got %s", tokenName(tokenPOpen), tokenName(p.peek().typ),
+		)
+	}
+	p.acceptBlanks()
+	args := make([]argument, 0, 4)
+	for !p.accept(tokenPClose) {
+		if !p.accept(tokenIdentifier) {
+			return nil, p.errorf(
+				"expected %s but got %s", tokenName(tokenIdentifier), tokenName(p.peek().typ),
+			)
+		}
+		left := node.NewPosNode(p.token.pos, &identifierNode{p.token.val, true})
+		var typ typeExpr
+		var defaultVal expr
+		if p.accept(tokenColon) {
+			p.acceptBlanks()
+			var err *node.ErrorNode
+			typ, err = p.acceptType()
+			if err != nil {
+				return nil, err
+			}
+		} else if p.accept(tokenEqual) {
+			p.acceptBlanks()
+			var err *node.ErrorNode
+			defaultVal, err = p.acceptExpr()
+			if err != nil {
+				return nil, err
+			}
+		}
+		args = append(args, argument{left, typ, defaultVal, false})
+		p.acceptBlanks()
+		if p.accept(tokenComma) {
+			p.acceptBlanks()
+		} else if !p.accept(tokenPClose) {
+			return nil, p.errorf(
+				"expected %s or %s but got %s",
+				tokenName(tokenComma), tokenName(tokenPClose), tokenName(p.peek().typ),
+			)
+		} else {
+			break
 		}
-		body = []node.Node{expr}
 	}
-
-	funcNode := &funcStmtOrExpr{
-		declare.TerminalNode().(*funcDeclareStatement),
-		bodyIsStmt,
-		body,
-		isExpr,
+	if !p.accept(tokenArrow) {
+		return nil, p.errorf(
+			"expected %s but got %s", tokenName(tokenArrow), tokenName(p.peek().typ),
+		)
 	}
-	return node.NewPosNode(declare.Position(), funcNode), nil
+	return args, nil
 }
 
 type funcDeclareStatement struct {
 	mods    []string
 	name    string
 	args    []argument
-	retType string
+	retType typeExpr // nil if not specified
 }
 
 // Clone clones itself.
@@ -1198,7 +2663,7 @@ func (p *parser) acceptFuncDeclare() (*node.PosNode, *node.ErrorNode) {
 	var mods []string
 	var name string
 	var args []argument
-	var retType string
+	var retType typeExpr
 	var err *node.ErrorNode
 
 	// Modifiers
@@ -1254,157 +2719,664 @@ func (p *parser) acceptModifiers() ([]string, *node.ErrorNode) {
 			break
 		}
 	}
-	return mods, nil
+	return mods, nil
+}
+
+// functionModifier := "noabort" | "autoload" | "global" | "range" | "dict" | "closure"
+func (p *parser) acceptFunctionModifier() bool {
+	if !p.accept(tokenIdentifier) {
+		return false
+	}
+	switch p.token.val {
+	case "noabort":
+	case "autoload":
+	case "global":
+	case "range":
+	case "dict":
+	case "closure":
+	default:
+		return false
+	}
+	return true
+}
+
+// functionCallSignature := "(" *blank
+//                            *( functionArgument *blank [ "," ] *blank )
+//                          ")" [ ":" type ]
+func (p *parser) acceptFunctionCallSignature() ([]argument, typeExpr, *node.ErrorNode) {
+	if !p.accept(tokenPOpen) {
+		return nil, nil, p.errorf(
+			"expected %s but got %s", tokenName(tokenPOpen), tokenName(p.peek().typ),
+		)
+	}
+	p.acceptBlanks()
+
+	var args []argument
+	if !p.accept(tokenPClose) {
+		args = make([]argument, 0, 8)
+		for {
+			arg, err := p.acceptFunctionArgument()
+			if err != nil {
+				return nil, nil, err
+			}
+			args = append(args, *arg)
+			p.acceptBlanks()
+			p.accept(tokenComma)
+			p.acceptBlanks()
+			if p.accept(tokenPClose) {
+				break
+			}
+			if arg.variadic {
+				return nil, nil, p.errorf(
+					"expected %s after variadic argument but got %s",
+					tokenName(tokenPClose), tokenName(p.peek().typ),
+				)
+			}
+		}
+	}
+
+	var retType typeExpr
+	if p.accept(tokenColon) {
+		var err *node.ErrorNode
+		retType, err = p.acceptType()
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return args, retType, nil
+}
+
+type argument struct {
+	left       node.Node
+	typ        typeExpr // nil if not specified
+	defaultVal expr
+	variadic   bool // If true, typ is the type of each rest argument.
+}
+
+func (n *argument) Clone() *argument {
+	var left node.Node
+	if n.left != nil {
+		left = n.left.Clone()
+	}
+	var defaultVal expr
+	if n.defaultVal != nil {
+		defaultVal = n.defaultVal.Clone()
+	}
+	return &argument{left, n.typ, defaultVal, n.variadic}
+}
+
+// variableAndType := identifier ":" *blanks type
+func (p *parser) acceptVariableAndType() (*argument, *node.ErrorNode) {
+	if !p.accept(tokenIdentifier) {
+		return nil, p.errorf(
+			"expected %s but got %s", tokenName(tokenIdentifier), tokenName(p.peek().typ),
+		)
+	}
+	idToken := p.token
+	left := node.NewPosNode(p.token.pos, &identifierNode{p.token.val, true})
+
+	if !p.accept(tokenColon) {
+		p.unshift(idToken)
+		return nil, p.errorf(
+			"expected %s but got %s", tokenName(tokenColon), tokenName(p.peek().typ),
+		)
+	}
+
+	p.acceptBlanks()
+	typ, err := p.acceptType()
+	if err != nil {
+		p.unshift(idToken)
+		return nil, err
+	}
+	return &argument{left, typ, nil, false}, nil
+}
+
+// functionArgument := identifier ":" *blanks [ "..." ] type /
+//                     identifier "=" *blanks expr
+func (p *parser) acceptFunctionArgument() (*argument, *node.ErrorNode) {
+	var typ typeExpr
+
+	if !p.accept(tokenIdentifier) {
+		return nil, p.errorf(
+			"expected %s but got %s", tokenName(tokenIdentifier), tokenName(p.peek().typ),
+		)
+	}
+	left := node.NewPosNode(p.token.pos, &identifierNode{p.token.val, true})
+
+	if p.accept(tokenColon) {
+		p.acceptBlanks()
+		variadic := p.accept(tokenDotDotDot)
+		var err *node.ErrorNode
+		typ, err = p.acceptType()
+		if err != nil {
+			return nil, err
+		}
+		return &argument{left, typ, nil, variadic}, nil
+	} else if p.accept(tokenEqual) {
+		p.acceptBlanks()
+		expr, err := p.acceptExpr()
+		if err != nil {
+			return nil, err
+		}
+		return &argument{left, nil, expr, false}, nil
+	}
+
+	return nil, p.errorf(
+		"expected %s or %s but got %s",
+		tokenName(tokenColon),
+		tokenName(tokenEqual),
+		tokenName(p.peek().typ),
+	)
+}
+
+// typeDeclareStatement declares the record type.
+// The type is erased by translator (the value is a plain dictionary).
+type typeDeclareStatement struct {
+	typ *recordType
+}
+
+// Clone clones itself.
+func (n *typeDeclareStatement) Clone() node.Node {
+	return &typeDeclareStatement{n.typ}
+}
+
+func (n *typeDeclareStatement) TerminalNode() node.Node {
+	return n
+}
+
+func (n *typeDeclareStatement) Position() *node.Pos {
+	return nil
+}
+
+func (n *typeDeclareStatement) IsExpr() bool {
+	return false
+}
+
+// isTypeDeclareStatement returns true if the next tokens are "type" identifier.
+// "type" is not a keyword because "type()" is a builtin function.
+func (p *parser) isTypeDeclareStatement() bool {
+	return p.isContextualKeyword("type", tokenIdentifier)
+}
+
+// typeDeclareStatement := "type" identifier "=" *blank recordType
+func (p *parser) acceptTypeDeclareStatement() (node.Node, *node.ErrorNode) {
+	if !p.isTypeDeclareStatement() {
+		return nil, p.errorf("expected type declaration but got %s", tokenName(p.peek().typ))
+	}
+	p.next()
+	pos := p.token.pos
+	p.next()
+	name := p.token.val
+	if !unicode.IsUpper([]rune(name)[0]) {
+		return nil, p.errorf("type name must start with an uppercase letter: %s", name)
+	}
+	if isBuiltinType(name) {
+		return nil, p.errorf("cannot redeclare builtin type %s", name)
+	}
+	if !p.accept(tokenEqual) {
+		return nil, p.errorf("expected %s but got %s", tokenName(tokenEqual), tokenName(p.peek().typ))
+	}
+	p.acceptBlanks()
+	if p.peek().typ != tokenCOpen {
+		return nil, p.errorf("expected record type but got %s", tokenName(p.peek().typ))
+	}
+	rec, err := p.acceptRecordType()
+	if err != nil {
+		return nil, err
+	}
+	rec.name = name
+	return node.NewPosNode(pos, &typeDeclareStatement{rec}), nil
+}
+
+// enumStatement declares the enum type.
+// Each variant is translated to the script-local constant.
+type enumStatement struct {
+	typ *enumType
+}
+
+// Clone clones itself.
+func (n *enumStatement) Clone() node.Node {
+	return &enumStatement{n.typ}
+}
+
+func (n *enumStatement) TerminalNode() node.Node {
+	return n
+}
+
+func (n *enumStatement) Position() *node.Pos {
+	return nil
+}
+
+func (n *enumStatement) IsExpr() bool {
+	return false
+}
+
+// isEnumStatement returns true if the next tokens are "enum" identifier.
+func (p *parser) isEnumStatement() bool {
+	return p.isContextualKeyword("enum", tokenIdentifier)
+}
+
+// enumStatement := "enum" identifier "{" *blank
+//                    enumVariant *( *blank "," *blank enumVariant ) *blank [ "," ]
+//                  *blank "}"
+// enumVariant := identifier [ "=" ( int | string ) ]
+func (p *parser) acceptEnumStatement() (node.Node, *node.ErrorNode) {
+	if !p.isEnumStatement() {
+		return nil, p.errorf("expected enum statement but got %s", tokenName(p.peek().typ))
+	}
+	p.next()
+	pos := p.token.pos
+	p.next()
+	name := p.token.val
+	if !unicode.IsUpper([]rune(name)[0]) {
+		return nil, p.errorf("enum name must start with an uppercase letter: %s", name)
+	}
+	if isBuiltinType(name) {
+		return nil, p.errorf("cannot redeclare builtin type %s", name)
+	}
+	if !p.accept(tokenCOpen) {
+		return nil, p.errorf("expected %s but got %s", tokenName(tokenCOpen), tokenName(p.peek().typ))
+	}
+	p.acceptBlanks()
+	e := &enumType{name, make([]enumVariant, 0, 8), false}
+	next := int64(0)
+	for !p.accept(tokenCClose) {
+		if !p.accept(tokenIdentifier) {
+			return nil, p.errorf("expected %s but got %s", tokenName(tokenIdentifier), tokenName(p.peek().typ))
+		}
+		v := enumVariant{p.token.val, strconv.FormatInt(next, 10), false}
+		if e.getVariant(v.name) != nil {
+			return nil, p.errorf("duplicate variant %s in enum %s", v.name, name)
+		}
+		var isString bool
+		if p.accept(tokenEqual) {
+			switch t := p.next(); t.typ {
+			case tokenInt:
+				n, err := strconv.ParseInt(t.val, 0, 64)
+				if err != nil {
+					return nil, p.errorf("invalid value of variant %s: %s", v.name, t.val)
+				}
+				v.value = t.val
+				next = n
+			case tokenString:
+				v.value = t.val
+				isString = true
+			default:
+				return nil, p.errorf("expected %s or %s but got %s",
+					tokenName(tokenInt), tokenName(tokenString), tokenName(t.typ))
+			}
+			v.explicit = true
+		}
+		if len(e.variants) == 0 {
+			e.isString = isString
+		} else if isString != e.isString {
+			return nil, p.errorf("all variants of enum %s must have the values of the same type", name)
+		}
+		next++
+		e.variants = append(e.variants, v)
+		p.acceptBlanks()
+		if p.accept(tokenComma) {
+			p.acceptBlanks()
+		} else if !p.accept(tokenCClose) {
+			return nil, p.errorf(
+				"expected %s or %s but got %s",
+				tokenName(tokenComma), tokenName(tokenCClose), tokenName(p.peek().typ),
+			)
+		} else {
+			break
+		}
+	}
+	if len(e.variants) == 0 {
+		return nil, p.errorf("enum %s must have at least one variant", name)
+	}
+	return node.NewPosNode(pos, &enumStatement{e}), nil
+}
+
+// typeExpr is the type expression of declarations
+// (e.g. "Int", "List<String>", "Func(Int): Bool", "Int?", "Int | String").
+// Type expressions are immutable, so they are shared among cloned nodes.
+type typeExpr interface {
+	String() string
+}
+
+// namedType is the type referred by its name (e.g. "Int", "List", "Any").
+type namedType struct {
+	name string
+}
+
+func (t *namedType) String() string {
+	return t.name
+}
+
+// listType is "List<elem>".
+type listType struct {
+	elem typeExpr
+}
+
+func (t *listType) String() string {
+	return "List<" + t.elem.String() + ">"
+}
+
+// dictType is "Dict<value>". The key type of Dict is always String.
+type dictType struct {
+	value typeExpr
+}
+
+func (t *dictType) String() string {
+	return "Dict<" + t.value.String() + ">"
+}
+
+// funcType is "Func(args...): ret".
+// ret is nil if the return type is not specified.
+type funcType struct {
+	args []typeExpr
+	ret  typeExpr
+}
+
+func (t *funcType) String() string {
+	args := make([]string, len(t.args))
+	for i := range t.args {
+		args[i] = t.args[i].String()
+	}
+	s := "Func(" + strings.Join(args, ", ") + ")"
+	if t.ret != nil {
+		s += ": " + t.ret.String()
+	}
+	return s
 }
 
-// functionModifier := "noabort" | "autoload" | "global" | "range" | "dict" | "closure"
-func (p *parser) acceptFunctionModifier() bool {
-	if !p.accept(tokenIdentifier) {
-		return false
+// optionalType is "elem?", which also accepts None.
+type optionalType struct {
+	elem typeExpr
+}
+
+func (t *optionalType) String() string {
+	return typeOperandString(t.elem) + "?"
+}
+
+// unionType is "A | B | ...".
+type unionType struct {
+	types []typeExpr
+}
+
+func (t *unionType) String() string {
+	types := make([]string, len(t.types))
+	for i := range t.types {
+		types[i] = typeOperandString(t.types[i])
 	}
-	switch p.token.val {
-	case "noabort":
-	case "autoload":
-	case "global":
-	case "range":
-	case "dict":
-	case "closure":
-	default:
-		return false
+	return strings.Join(types, " | ")
+}
+
+// recordType is "{name: type, ...}", which is the type of dictionaries
+// with the fixed keys. name is empty if it is not declared by "type".
+type recordType struct {
+	name   string
+	fields []recordField
+}
+
+// recordField is the field of record type.
+// If optional is true, the key may not exist ("name?: type").
+type recordField struct {
+	name     string
+	typ      typeExpr
+	optional bool
+}
+
+func (t *recordType) String() string {
+	if t.name != "" {
+		return t.name
 	}
-	return true
+	return t.fieldsString()
 }
 
-// functionCallSignature := "(" *blank
-//                            *( functionArgument *blank [ "," ] *blank )
-//                          ")" [ ":" type ]
-func (p *parser) acceptFunctionCallSignature() ([]argument, string, *node.ErrorNode) {
-	if !p.accept(tokenPOpen) {
-		return nil, "", p.errorf(
-			"expected %s but got %s", tokenName(tokenPOpen), tokenName(p.peek().typ),
-		)
+// fieldsString returns the record type as "{name: type, ...}".
+func (t *recordType) fieldsString() string {
+	fields := make([]string, len(t.fields))
+	for i := range t.fields {
+		fields[i] = t.fields[i].name
+		if t.fields[i].optional {
+			fields[i] += "?"
+		}
+		fields[i] += ": " + t.fields[i].typ.String()
 	}
-	p.acceptBlanks()
+	return "{" + strings.Join(fields, ", ") + "}"
+}
 
-	var args []argument
-	if !p.accept(tokenPClose) {
-		args = make([]argument, 0, 8)
-		for {
-			arg, err := p.acceptFunctionArgument()
-			if err != nil {
-				return nil, "", err
-			}
-			args = append(args, *arg)
-			p.acceptBlanks()
-			p.accept(tokenComma)
-			p.acceptBlanks()
-			if p.accept(tokenPClose) {
-				break
-			}
+// getField returns the field of the name.
+func (t *recordType) getField(name string) *recordField {
+	for i := range t.fields {
+		if t.fields[i].name == name {
+			return &t.fields[i]
 		}
 	}
+	return nil
+}
 
-	var retType string
-	if p.accept(tokenColon) {
-		var err *node.ErrorNode
-		retType, err = p.acceptType()
-		if err != nil {
-			return nil, "", err
+// enumType is the type declared by enumStatement.
+// The values of variants are Int, or String if isString is true.
+type enumType struct {
+	name     string
+	variants []enumVariant
+	isString bool
+}
+
+// enumVariant is the variant of enum.
+// value is the literal of Int or String.
+// If explicit is false, value was numbered automatically.
+type enumVariant struct {
+	name     string
+	value    string
+	explicit bool
+}
+
+func (t *enumType) String() string {
+	return t.name
+}
+
+// getVariant returns the variant of the name.
+func (t *enumType) getVariant(name string) *enumVariant {
+	for i := range t.variants {
+		if t.variants[i].name == name {
+			return &t.variants[i]
 		}
 	}
-	return args, retType, nil
+	return nil
 }
 
-type argument struct {
-	left       node.Node
-	typ        string
-	defaultVal expr
+// valueType returns the type of the values of variants.
+func (t *enumType) valueType() string {
+	if t.isString {
+		return typeString
+	}
+	return typeInt
+}
+
+// typeOperandString returns the string of t as the operand of "?" or "|".
+// Union types and function types with return type are enclosed by parens
+// because they take "?" and "|" away from the operand.
+func typeOperandString(t typeExpr) string {
+	switch tt := t.(type) {
+	case *unionType:
+		return "(" + tt.String() + ")"
+	case *funcType:
+		if tt.ret != nil {
+			return "(" + tt.String() + ")"
+		}
+	}
+	return t.String()
 }
 
-func (n *argument) Clone() *argument {
-	var left node.Node
-	if n.left != nil {
-		left = n.left.Clone()
+// type := optionalType *( "|" optionalType )
+func (p *parser) acceptType() (typeExpr, *node.ErrorNode) {
+	t, err := p.acceptOptionalType()
+	if err != nil {
+		return nil, err
 	}
-	var defaultVal expr
-	if n.defaultVal != nil {
-		defaultVal = n.defaultVal.Clone()
+	if p.peek().typ != tokenOr {
+		return t, nil
+	}
+	types := []typeExpr{t}
+	for p.accept(tokenOr) {
+		t, err := p.acceptOptionalType()
+		if err != nil {
+			return nil, err
+		}
+		types = append(types, t)
 	}
-	return &argument{left, n.typ, defaultVal}
+	return &unionType{types}, nil
 }
 
-// variableAndType := identifier ":" *blanks type
-func (p *parser) acceptVariableAndType() (*argument, *node.ErrorNode) {
+// optionalType := primaryType [ "?" ]
+func (p *parser) acceptOptionalType() (typeExpr, *node.ErrorNode) {
+	t, err := p.acceptPrimaryType()
+	if err != nil {
+		return nil, err
+	}
+	if p.accept(tokenQuestion) {
+		return &optionalType{t}, nil
+	}
+	return t, nil
+}
+
+// primaryType := "List" "<" type ">" /
+//                "Dict" "<" type ">" /
+//                "Func" "(" [ type *( "," type ) ] ")" [ ":" type ] /
+//                recordType /
+//                "(" type ")" /
+//                identifier
+func (p *parser) acceptPrimaryType() (typeExpr, *node.ErrorNode) {
+	if p.peek().typ == tokenCOpen {
+		return p.acceptRecordType()
+	}
+	if p.accept(tokenPOpen) {
+		t, err := p.acceptType()
+		if err != nil {
+			return nil, err
+		}
+		if !p.accept(tokenPClose) {
+			return nil, p.errorf(
+				"expected %s but got %s", tokenName(tokenPClose), tokenName(p.peek().typ),
+			)
+		}
+		return t, nil
+	}
 	if !p.accept(tokenIdentifier) {
 		return nil, p.errorf(
 			"expected %s but got %s", tokenName(tokenIdentifier), tokenName(p.peek().typ),
 		)
 	}
-	idToken := p.token
-	left := node.NewPosNode(p.token.pos, &identifierNode{p.token.val, true})
-
-	if !p.accept(tokenColon) {
-		p.unshift(idToken)
-		return nil, p.errorf(
-			"expected %s but got %s", tokenName(tokenColon), tokenName(p.peek().typ),
-		)
+	name := p.token.val
+	switch {
+	case name == typeFunc && p.peek().typ == tokenPOpen:
+		return p.acceptFuncType()
+	case p.peek().typ != tokenLt:
+		return &namedType{name}, nil
+	case name != typeList && name != typeDict:
+		return nil, p.errorf("type %s does not take type arguments", name)
 	}
-
-	p.acceptBlanks()
-	typ, err := p.acceptType()
+	p.accept(tokenLt)
+	arg, err := p.acceptType()
 	if err != nil {
-		p.unshift(idToken)
 		return nil, err
 	}
-	return &argument{left, typ, nil}, nil
+	var t typeExpr
+	if name == typeList {
+		t = &listType{arg}
+	} else {
+		t = &dictType{arg}
+	}
+	// ">?" is lexed as one token (case-insensitive comparison operator)
+	if p.accept(tokenGtCi) {
+		return &optionalType{t}, nil
+	}
+	if !p.accept(tokenGt) {
+		return nil, p.errorf(
+			"expected %s but got %s", tokenName(tokenGt), tokenName(p.peek().typ),
+		)
+	}
+	return t, nil
 }
 
-// functionArgument := identifier ":" *blanks type /
-//                     identifier "=" *blanks expr
-func (p *parser) acceptFunctionArgument() (*argument, *node.ErrorNode) {
-	var typ string
-
-	if !p.accept(tokenIdentifier) {
+// recordType := "{" *blank
+//                 [ recordField *( *blank "," *blank recordField ) *blank [ "," ] ]
+//               *blank "}"
+// recordField := identifier [ "?" ] ":" *blank type
+func (p *parser) acceptRecordType() (*recordType, *node.ErrorNode) {
+	if !p.accept(tokenCOpen) {
 		return nil, p.errorf(
-			"expected %s but got %s", tokenName(tokenIdentifier), tokenName(p.peek().typ),
+			"expected %s but got %s", tokenName(tokenCOpen), tokenName(p.peek().typ),
 		)
 	}
-	left := node.NewPosNode(p.token.pos, &identifierNode{p.token.val, true})
-
-	if p.accept(tokenColon) {
+	p.acceptBlanks()
+	rec := &recordType{"", make([]recordField, 0, 8)}
+	for !p.accept(tokenCClose) {
+		if !p.accept(tokenIdentifier) {
+			return nil, p.errorf(
+				"expected %s but got %s", tokenName(tokenIdentifier), tokenName(p.peek().typ),
+			)
+		}
+		name := p.token.val
+		if rec.getField(name) != nil {
+			return nil, p.errorf("duplicate field %s in record type", name)
+		}
+		optional := p.accept(tokenQuestion)
+		if !p.accept(tokenColon) {
+			return nil, p.errorf(
+				"expected %s but got %s", tokenName(tokenColon), tokenName(p.peek().typ),
+			)
+		}
 		p.acceptBlanks()
-		var err *node.ErrorNode
-		typ, err = p.acceptType()
+		typ, err := p.acceptType()
 		if err != nil {
 			return nil, err
 		}
-		return &argument{left, typ, nil}, nil
-	} else if p.accept(tokenEqual) {
+		rec.fields = append(rec.fields, recordField{name, typ, optional})
 		p.acceptBlanks()
-		expr, err := p.acceptExpr()
-		if err != nil {
-			return nil, err
+		if p.accept(tokenComma) {
+			p.acceptBlanks()
+		} else if !p.accept(tokenCClose) {
+			return nil, p.errorf(
+				"expected %s or %s but got %s",
+				tokenName(tokenComma), tokenName(tokenCClose), tokenName(p.peek().typ),
+			)
+		} else {
+			break
 		}
-		return &argument{left, "", expr}, nil
 	}
-
-	return nil, p.errorf(
-		"expected %s or %s but got %s",
-		tokenName(tokenColon),
-		tokenName(tokenEqual),
-		tokenName(p.peek().typ),
-	)
+	return rec, nil
 }
 
-// TODO: Complex type like array, dictionary, generics...
-// type := identifier
-func (p *parser) acceptType() (string, *node.ErrorNode) {
-	if !p.accept(tokenIdentifier) {
-		return "", p.errorf(
-			"expected %s but got %s", tokenName(tokenIdentifier), tokenName(p.peek().typ),
+// funcType := "Func" "(" [ type *( "," type ) ] ")" [ ":" type ]
+// "Func" was already consumed by acceptPrimaryType().
+func (p *parser) acceptFuncType() (typeExpr, *node.ErrorNode) {
+	if !p.accept(tokenPOpen) {
+		return nil, p.errorf(
+			"expected %s but got %s", tokenName(tokenPOpen), tokenName(p.peek().typ),
 		)
 	}
-	return p.token.val, nil
+	args := make([]typeExpr, 0, 4)
+	for !p.accept(tokenPClose) {
+		if len(args) > 0 && !p.accept(tokenComma) {
+			return nil, p.errorf(
+				"expected %s or %s but got %s",
+				tokenName(tokenComma), tokenName(tokenPClose), tokenName(p.peek().typ),
+			)
+		}
+		t, err := p.acceptType()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, t)
+	}
+	var ret typeExpr
+	if p.accept(tokenColon) {
+		var err *node.ErrorNode
+		ret, err = p.acceptType()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &funcType{args, ret}, nil
 }
 
 func (p *parser) acceptExpr() (expr, *node.ErrorNode) {
@@ -1415,7 +3387,7 @@ func (p *parser) acceptExpr() (expr, *node.ErrorNode) {
 	return p.acceptExpr0()
 }
 
-// expr0 := assignExpr | expr1
+// expr0 := assignExpr | compoundAssignExpr | expr1
 func (p *parser) acceptExpr0() (expr, *node.ErrorNode) {
 	p.save()
 	if assign, err := p.acceptAssignExpr(); err == nil {
@@ -1423,6 +3395,12 @@ func (p *parser) acceptExpr0() (expr, *node.ErrorNode) {
 		return assign, nil
 	}
 	p.restore()
+	p.save()
+	if assign, err := p.acceptCompoundAssignExpr(); err == nil {
+		p.forget()
+		return assign, nil
+	}
+	p.restore()
 	return p.acceptExpr1()
 }
 
@@ -2480,8 +4458,39 @@ func (n *subtractNode) Right() node.Node {
 	return n.right
 }
 
+type concatNode struct {
+	left  expr
+	right expr
+}
+
+// Clone clones itself.
+func (n *concatNode) Clone() node.Node {
+	return &concatNode{n.left.Clone(), n.right.Clone()}
+}
+
+func (n *concatNode) TerminalNode() node.Node {
+	return n
+}
+
+func (n *concatNode) Position() *node.Pos {
+	return nil
+}
+
+func (n *concatNode) IsExpr() bool {
+	return true
+}
+
+func (n *concatNode) Left() node.Node {
+	return n.left
+}
+
+func (n *concatNode) Right() node.Node {
+	return n.right
+}
+
 // expr5 := expr6 1*( "+" *blank expr6 ) /
 //          expr6 1*( "-" *blank expr6 ) /
+//          expr6 1*( ".." *blank expr6 ) /
 //          expr6
 func (p *parser) acceptExpr5() (expr, *node.ErrorNode) {
 	left, err := p.acceptExpr6()
@@ -2509,6 +4518,16 @@ func (p *parser) acceptExpr5() (expr, *node.ErrorNode) {
 			}
 			n.right = right
 			left = node.NewPosNode(pos, n)
+		} else if p.accept(tokenDotDot) {
+			pos := p.token.pos
+			n := &concatNode{left, nil}
+			p.acceptBlanks()
+			right, err := p.acceptExpr6()
+			if err != nil {
+				return nil, err
+			}
+			n.right = right
+			left = node.NewPosNode(pos, n)
 		} else {
 			break
 		}
@@ -3072,6 +5091,46 @@ func (n *floatNode) IsExpr() bool {
 	return true
 }
 
+type boolNode struct {
+	value bool
+}
+
+// Clone clones itself.
+func (n *boolNode) Clone() node.Node {
+	return &boolNode{n.value}
+}
+
+func (n *boolNode) TerminalNode() node.Node {
+	return n
+}
+
+func (n *boolNode) Position() *node.Pos {
+	return nil
+}
+
+func (n *boolNode) IsExpr() bool {
+	return true
+}
+
+type noneNode struct{}
+
+// Clone clones itself.
+func (n *noneNode) Clone() node.Node {
+	return &noneNode{}
+}
+
+func (n *noneNode) TerminalNode() node.Node {
+	return n
+}
+
+func (n *noneNode) Position() *node.Pos {
+	return nil
+}
+
+func (n *noneNode) IsExpr() bool {
+	return true
+}
+
 type stringNode struct {
 	value vainString
 }
@@ -3095,6 +5154,7 @@ func (n *stringNode) IsExpr() bool {
 
 type listNode struct {
 	value []expr
+	rest  bool // If true, the last element is the rest element of destructuring.
 }
 
 // Clone clones itself.
@@ -3103,7 +5163,7 @@ func (n *listNode) Clone() node.Node {
 	for i := range n.value {
 		value[i] = n.value[i].Clone()
 	}
-	return &listNode{value}
+	return &listNode{value, n.rest}
 }
 
 func (n *listNode) TerminalNode() node.Node {
@@ -3224,11 +5284,14 @@ func (n *regNode) Value() string {
 
 // expr9: int /
 //        float /
+//        "true" / "false" /
+//        "null" / "none" /
 //        (string ABNF is too complex! e.g. "string\n", 'str''ing') /
 //        "[" *blank *( expr1 *blank "," *blank ) "]" /
 //        "{" *blank *( expr1 *blank ":" *blank expr1 *blank "," *blank ) "}" /
 //        &option /
 //        "(" *blank expr1 *blank ")" /
+//        arrowLambda /
 //        function /
 //        identifier /
 //        $VAR /
@@ -3240,11 +5303,17 @@ func (p *parser) acceptExpr9() (expr, *node.ErrorNode) {
 	} else if p.accept(tokenFloat) {
 		n := node.NewPosNode(p.token.pos, &floatNode{p.token.val})
 		return n, nil
+	} else if p.accept(tokenBool) {
+		n := node.NewPosNode(p.token.pos, &boolNode{p.token.val == "true"})
+		return n, nil
+	} else if p.accept(tokenNone) {
+		n := node.NewPosNode(p.token.pos, &noneNode{})
+		return n, nil
 	} else if p.accept(tokenString) {
 		n := node.NewPosNode(p.token.pos, &stringNode{vainString(p.token.val)})
 		return n, nil
 	} else if p.accept(tokenSqOpen) {
-		n := &listNode{make([]expr, 0, 16)}
+		n := &listNode{make([]expr, 0, 16), false}
 		p.acceptBlanks()
 		if !p.accept(tokenSqClose) {
 			for {
@@ -3300,17 +5369,29 @@ func (p *parser) acceptExpr9() (expr, *node.ErrorNode) {
 				p.acceptBlanks()
 				if p.accept(tokenComma) {
 					p.acceptBlanks()
-					if p.accept(tokenSqClose) {
+					if p.accept(tokenCClose) {
 						break
 					}
 				} else if p.accept(tokenCClose) {
 					break
+				} else {
+					return nil, p.errorf(
+						"expected %s or %s but got %s",
+						tokenName(tokenComma),
+						tokenName(tokenCClose),
+						tokenName(p.peek().typ),
+					)
 				}
 			}
 		}
 		n := node.NewPosNode(npos, &dictionaryNode{m})
 		return n, nil
 	} else if p.accept(tokenPOpen) {
+		p.backup()
+		if p.isArrowLambda() {
+			return p.acceptArrowLambda()
+		}
+		p.accept(tokenPOpen)
 		p.acceptBlanks()
 		n, err := p.acceptExpr()
 		if err != nil {
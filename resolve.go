@@ -0,0 +1,373 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/tyru/vain/node"
+)
+
+// newResolver is the constructor for resolver.
+// root is the project root directory, and
+// stdlib is the namespaces of "$vim/..." packages.
+func newResolver(root, vainroot string, stdlib *NamespaceDB) *resolver {
+	return &resolver{
+		root:     root,
+		vainroot: vainroot,
+		stdlib:   stdlib,
+		modules:  make(map[string]*module, 16),
+		reported: make(map[string]bool, 16),
+	}
+}
+
+// resolver resolves import paths to .vain files, and
+// collects the declarations of the modules.
+//
+// An import path is resolved as follows:
+// * "$vim/..." is the standard library ($VAINROOT/lib/vim.vain)
+// * "./foo", "../foo" is relative to the directory of the importing file
+// * "foo/bar" is relative to the project root, or $VAINROOT/lib
+//
+// The modules (except the standard library) must be in autoload directory,
+// because their functions are called as autoload functions.
+type resolver struct {
+	root     string
+	vainroot string
+	stdlib   *NamespaceDB
+
+	mu       sync.Mutex
+	modules  map[string]*module // cache of loaded modules (key is file path)
+	reported map[string]bool    // reported errors (not to report them twice)
+
+	visitMu sync.Mutex // lock for module.state and module.failure
+}
+
+// module is a loaded .vain file.
+type module struct {
+	file    string
+	imports []moduleImport
+	scope   *Scope      // declarations at the top level
+	nodes   []node.Node // parsed nodes (see parser.Run())
+	err     error       // error occurred while loading the file
+
+	state   visitState
+	failure *multierror.Error // errors of the module and its imported modules (set by visit())
+}
+
+// visitState is the state of module in visit().
+type visitState int
+
+const (
+	unvisited visitState = iota
+	visiting             // the module is in the import chain
+	visited              // failure is set
+)
+
+// moduleImport is an import statement in a module.
+type moduleImport struct {
+	path string // import path
+	pos  *node.Pos
+}
+
+// Resolve resolves the modules imported by the file name recursively,
+// and returns the module of the file and the namespaces of the imported
+// modules (and standard library).
+// The namespace name of each module is the import path in the file.
+// It returns the errors of the file and the imported modules
+// (missing modules, import cycles, syntax errors, ...).
+// The same errors are returned for each file importing the broken module,
+// so use unreported() not to report them twice.
+func (r *resolver) Resolve(name string) (*module, *NamespaceDB, error) {
+	db := make(NamespaceDB, 8)
+	if r.stdlib != nil {
+		for ns, scope := range *r.stdlib {
+			db.setNamespace(ns, scope)
+		}
+	}
+
+	m := r.load(name)
+	r.visitMu.Lock()
+	r.visit(m, nil)
+	err := m.failure.ErrorOrNil()
+	r.visitMu.Unlock()
+	if err != nil {
+		return m, &db, err
+	}
+
+	for _, imp := range m.imports {
+		if isBuiltinPkg(imp.path) {
+			if scope := db.getNamespace(BuiltinNamespace); scope != nil {
+				db.setNamespace(Namespace(imp.path), scope)
+			}
+			continue
+		}
+		file, err := r.resolvePath(m.file, imp.path)
+		if err != nil {
+			continue // already reported
+		}
+		if dep := r.load(file); dep.err == nil {
+			db.setNamespace(Namespace(imp.path), dep.scope)
+		}
+	}
+	return m, &db, nil
+}
+
+// Modules returns the file paths of the modules imported by the file name.
+// The key is the import path in the file.
+// The standard library and the missing modules are not included.
+func (r *resolver) Modules(name string) map[string]string {
+	m := r.load(name)
+	files := make(map[string]string, len(m.imports))
+	for _, imp := range m.imports {
+		if isBuiltinPkg(imp.path) {
+			continue
+		}
+		if file, err := r.resolvePath(m.file, imp.path); err == nil {
+			files[imp.path] = file
+		}
+	}
+	return files
+}
+
+// visit checks missing modules and import cycles from m,
+// and sets the errors to m.failure.
+// A module fails if it has errors, or it imports the failed module.
+// All modules in an import cycle fail.
+// stack is the import chain of the modules to m.
+func (r *resolver) visit(m *module, stack []*module) {
+	if m.state != unvisited {
+		return
+	}
+	m.state = visiting
+	stack = append(stack, m)
+	if m.err != nil {
+		m.failure = multierror.Append(m.failure, m.err)
+	}
+
+	for _, imp := range m.imports {
+		if isBuiltinPkg(imp.path) {
+			if !builtinPkgs[imp.path] {
+				m.failure = multierror.Append(m.failure, r.err(
+					fmt.Errorf("module not found: '%s'", imp.path),
+					m.file, imp.pos,
+				))
+			} else if r.stdlib == nil || r.stdlib.getNamespace(BuiltinNamespace) == nil {
+				m.failure = multierror.Append(m.failure, r.err(
+					fmt.Errorf("module not found: '%s' (could not read standard library)", imp.path),
+					m.file, imp.pos,
+				))
+			}
+			continue
+		}
+		file, err := r.resolvePath(m.file, imp.path)
+		if err != nil {
+			m.failure = multierror.Append(m.failure, r.err(err, m.file, imp.pos))
+			continue
+		}
+		// The functions are called by the autoload function names.
+		if _, err := getAutoloadPrefix(file); err != nil {
+			m.failure = multierror.Append(m.failure, r.err(
+				fmt.Errorf("cannot import '%s': %s", imp.path, err.Error()),
+				m.file, imp.pos,
+			))
+			continue
+		}
+		dep := r.load(file)
+		switch dep.state {
+		case unvisited:
+			r.visit(dep, stack)
+			if dep.failure != nil {
+				m.failure = multierror.Append(m.failure, dep.failure.Errors...)
+			}
+		case visiting:
+			cycle := stack[indexOf(stack, dep):]
+			err := r.cycleErr(cycle)
+			for _, c := range cycle {
+				c.failure = multierror.Append(c.failure, err)
+			}
+		case visited:
+			if dep.failure != nil {
+				m.failure = multierror.Append(m.failure, dep.failure.Errors...)
+			}
+		}
+	}
+	m.state = visited
+}
+
+// cycleErr returns the error of the import cycle.
+// The chain starts from the first file in lexical order, and the error is
+// reported at its import statement, so that the cycle is reported as
+// the same error from any module in the cycle.
+func (r *resolver) cycleErr(cycle []*module) error {
+	start := 0
+	for i := range cycle {
+		if cycle[i].file < cycle[start].file {
+			start = i
+		}
+	}
+	chain := make([]string, 0, len(cycle)+1)
+	for i := range cycle {
+		chain = append(chain, cycle[(start+i)%len(cycle)].file)
+	}
+	chain = append(chain, chain[0])
+	from := cycle[start]
+	var pos *node.Pos
+	for _, imp := range from.imports {
+		if file, err := r.resolvePath(from.file, imp.path); err == nil && file == chain[1] {
+			pos = imp.pos
+			break
+		}
+	}
+	return r.err(
+		fmt.Errorf("import cycle not allowed: %s", strings.Join(chain, " -> ")),
+		from.file, pos,
+	)
+}
+
+// err returns a positioned error.
+func (r *resolver) err(err error, file string, pos *node.Pos) error {
+	return newDiagnostic("resolve", file, pos, nil, err.Error())
+}
+
+// unreported returns the errors in err which are not returned by
+// unreported() yet, because the errors of a module are returned from
+// Resolve() for each file importing it.
+func (r *resolver) unreported(err error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var result *multierror.Error
+	for _, e := range flattenErrors(err) {
+		if !r.reported[e.Error()] {
+			r.reported[e.Error()] = true
+			result = multierror.Append(result, e)
+		}
+	}
+	return result.ErrorOrNil()
+}
+
+// resolvePath returns the file path of the import path in the file from.
+func (r *resolver) resolvePath(from, path string) (string, error) {
+	var candidates []string
+	if strings.HasPrefix(path, "./") || strings.HasPrefix(path, "../") {
+		candidates = []string{filepath.Join(filepath.Dir(from), path)}
+	} else {
+		candidates = []string{
+			filepath.Join(r.root, path),
+			filepath.Join(r.vainroot, "lib", path),
+		}
+	}
+	for _, file := range candidates {
+		if !strings.HasSuffix(file, ".vain") {
+			file += ".vain"
+		}
+		if fi, err := os.Stat(file); err == nil && !fi.IsDir() {
+			return filepath.Clean(file), nil
+		}
+	}
+	return "", fmt.Errorf("module not found: '%s'", path)
+}
+
+// load loads the file and returns the module.
+// The loaded module is cached.
+func (r *resolver) load(file string) *module {
+	file = filepath.Clean(file)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if m, ok := r.modules[file]; ok {
+		return m
+	}
+	m := &module{file: file}
+	m.imports, m.scope, m.nodes, m.err = r.parseModule(file)
+	r.modules[file] = m
+	return m
+}
+
+// parseModule parses the file and returns the import statements,
+// the declarations at the top level, and the parsed nodes.
+func (r *resolver) parseModule(name string) ([]moduleImport, *Scope, []node.Node, error) {
+	src, err := os.Open(name)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	var content strings.Builder
+	_, err = io.Copy(&content, src)
+	src.Close()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	lexer := lex(name, content.String())
+	parser := parse(name, lexer.Tokens(), false)
+	go lexer.Run()
+	go parser.Run()
+
+	imports := make([]moduleImport, 0, 8)
+	scope := NewScope()
+	scope.push()
+	nodes := make([]node.Node, 0, 1)
+	var result *multierror.Error
+	for n := range parser.Nodes() {
+		nodes = append(nodes, n)
+		if e, ok := n.TerminalNode().(*node.ErrorNode); ok {
+			result = multierror.Append(result, e)
+			continue
+		}
+		top, ok := n.TerminalNode().(*topLevelNode)
+		if !ok {
+			continue
+		}
+		for i := range top.body {
+			switch nn := top.body[i].TerminalNode().(type) {
+			case *importStatement:
+				path, err := nn.pkg.eval()
+				if err != nil {
+					pos := top.body[i].Position()
+					result = multierror.Append(result, newDiagnostic("resolve", name, pos, nil, err.Error()))
+					continue
+				}
+				imports = append(imports, moduleImport{path, top.body[i].Position()})
+			case *funcStmtOrExpr:
+				if !nn.isExpr && nn.declare.name != "" {
+					scope.addConstVar(&identifierNode{nn.declare.name, true})
+					scope.setFunc(nn.declare.name, nn.declare)
+				}
+			case *funcDeclareStatement:
+				if nn.name != "" {
+					scope.addConstVar(&identifierNode{nn.name, true})
+					scope.setFunc(nn.name, nn)
+				}
+			}
+		}
+	}
+	return imports, scope, nodes, result.ErrorOrNil()
+}
+
+// builtinPkgPrefix is the prefix of standard library packages.
+const builtinPkgPrefix = "$vim/"
+
+// builtinPkgs are the packages of the standard library.
+// All of them are the namespace of $VAINROOT/lib/vim.vain for now.
+var builtinPkgs = map[string]bool{
+	"$vim/ex": true,
+	"$vim/fn": true,
+}
+
+// isBuiltinPkg returns true if path is the standard library package.
+func isBuiltinPkg(path string) bool {
+	return strings.HasPrefix(path, builtinPkgPrefix)
+}
+
+func indexOf(stack []*module, m *module) int {
+	for i := range stack {
+		if stack[i] == m {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,10 @@
+scriptencoding utf-8
+function! cb#hello() abort
+  call echo('hello')
+endfunction
+function! cb#greet(name) abort
+  call echo('hello, ' . name)
+endfunction
+nnoremap <Leader>h :<C-u>call cb#hello()<CR>
+command! -nargs=1 Greet call cb#greet(<f-args>)
+autocmd FileType vain call cb#hello()
\ No newline at end of file
@@ -0,0 +1,8 @@
+scriptencoding utf-8
+let s:greet = function('cb#greet')
+function! s:hello() abort
+  call echo('hello')
+endfunction
+nnoremap <Leader>h :<C-u>call <SID>hello()<CR>
+command! Hello call s:hello()
+command! -nargs=1 Greet call s:greet(<f-args>)
\ No newline at end of file
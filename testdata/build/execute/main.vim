@@ -0,0 +1,7 @@
+scriptencoding utf-8
+let file = 'a|b "c".txt'
+execute "edit " . fnameescape(file)
+execute 'echo ' . string(file)
+function! s:open(name,line) abort
+  execute "edit +" . fnameescape(line) . " " . fnameescape(name)
+endfunction
\ No newline at end of file
@@ -0,0 +1,7 @@
+scriptencoding utf-8
+function! util#str#quote(s) abort
+  return (("'" . s) . "'")
+endfunction
+function! util#str#first(xs) abort
+  return xs[0]
+endfunction
\ No newline at end of file
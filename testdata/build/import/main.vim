@@ -0,0 +1,10 @@
+scriptencoding utf-8
+
+let s:quote = function('util#str#quote')
+let s:f = function('util#str#first')
+let s:exe = function('execute')
+function! s:greet() abort
+  call s:exe("echo 1")
+  return ((s:quote("a") . s:f(["b"])) . util#str#quote("c"))
+endfunction
+let top = s:quote("top")
\ No newline at end of file
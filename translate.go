@@ -3,15 +3,45 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/tyru/vain/node"
 )
 
-func translate(name string, inNodes <-chan node.Node) *translator {
-	return &translator{name, inNodes, make(chan io.Reader), "  ", 0, make([]io.Reader, 0, 16), 0}
+// translate is the constructor for translator.
+// modules is the file paths of the imported modules (key is the import path).
+func translate(name string, inNodes <-chan node.Node, target vimTarget, modules map[string]string) *translator {
+	// The functions in autoload directory are autoload functions.
+	autoloadPrefix, _ := getAutoloadPrefix(name)
+	return &translator{
+		name, inNodes, make(chan io.Reader), "  ", 0, make([]io.Reader, 0, 16), 0,
+		make(map[string]string, 8), make(map[string]string, 8), make(map[string]string, 8),
+		make(map[string]*enumType, 8), make(map[string]string, 8), 0, target, modules, autoloadPrefix,
+	}
+}
+
+// vimTarget is the version of Vim which runs the translated Vim script.
+type vimTarget int
+
+const (
+	targetVim80 vimTarget = iota // Vim 8.0 (default)
+	targetVim82                  // Vim 8.2 (e.g. supports default argument syntax)
+)
+
+// parseVimTarget parses the value of --target option.
+func parseVimTarget(s string) (vimTarget, error) {
+	switch s {
+	case "vim8.0":
+		return targetVim80, nil
+	case "vim8.2":
+		return targetVim82, nil
+	}
+	return targetVim80, fmt.Errorf("unknown target: %s (must be vim8.0 or vim8.2)", s)
 }
 
 type translator struct {
@@ -22,10 +52,23 @@ type translator struct {
 	level          int
 	namedExprFuncs []io.Reader
 	lambdaFuncID   int
+	importedFuncs  map[string]string    // imported (or autoload) function name -> Vim script expression
+	importedPkgs   map[string]string    // package name -> function name prefix
+	lambdaArgs     map[string]string    // argument name of current lambda -> Vim script expression
+	enums          map[string]*enumType // enums declared at top level
+	funcNames      map[string]string    // function name declared at top level -> Vim script function name
+	tmpVarID       int
+	target         vimTarget
+	modules        map[string]string // import path -> file path of the module
+	autoloadPrefix string            // prefix of function names ("" if the file is not in autoload directory)
 }
 
 func (t *translator) Run() {
 	for node := range t.inNodes {
+		if err, ok := node.TerminalNode().(error); ok {
+			t.emit(&errorReader{err}) // error or warning
+			continue
+		}
 		toplevel := t.toReader(node, nil)
 		t.emit(strings.NewReader("scriptencoding utf-8\n"))
 		if len(t.namedExprFuncs) > 0 {
@@ -53,14 +96,7 @@ func (t *translator) emit(r io.Reader) {
 }
 
 func (t *translator) err(err error, n node.Node) io.Reader {
-	if pos := n.Position(); pos != nil {
-		return &errorReader{
-			fmt.Errorf("[translate] %s:%d:%d: "+err.Error(), t.name, pos.Line(), pos.Col()+1),
-		}
-	}
-	return &errorReader{
-		fmt.Errorf("[translate] %s: "+err.Error(), t.name),
-	}
+	return &errorReader{newDiagnosticFromError("translate", t.name, n.Position(), err)}
 }
 
 func (t *translator) incIndent() {
@@ -98,12 +134,42 @@ func (t *translator) toReader(node, parent node.Node) io.Reader {
 		return t.newAssignStatementReader(n, parent)
 	case *assignExpr:
 		return t.newAssignStatementReader(n, parent)
+	case *compoundAssignExpr:
+		return t.newAssignStatementReader(n, parent)
 	case *ifStatement:
 		return t.newIfStatementReader(n, parent, true)
 	case *whileStatement:
 		return t.newWhileStatementReader(n, parent)
 	case *forStatement:
 		return t.newForStatementReader(n, parent)
+	case *tryStatement:
+		return t.newTryStatementReader(n, parent)
+	case *matchStatement:
+		return t.newMatchStatementReader(n, parent)
+	case *throwStatement:
+		return t.newThrowStatementReader(n, parent)
+	case *breakStatement:
+		return strings.NewReader("break")
+	case *vimBlockStatement:
+		return t.newVimBlockStatementReader(n, parent)
+	case *executeStatement:
+		return t.newExecuteStatementReader(n, parent)
+	case *templateNode:
+		return t.newTemplateNodeReader(n, parent)
+	case *mapStatement:
+		return t.newMapStatementReader(n, parent)
+	case *commandStatement:
+		return t.newCommandStatementReader(n, parent)
+	case *autocmdStatement:
+		return t.newAutocmdStatementReader(n, parent)
+	case *augroupStatement:
+		return t.newAugroupStatementReader(n, parent)
+	case *typeDeclareStatement:
+		return t.newTypeDeclareStatementReader(n, parent)
+	case *enumStatement:
+		return t.newEnumStatementReader(n, parent)
+	case *continueStatement:
+		return strings.NewReader("continue")
 	case *ternaryNode:
 		return t.newTernaryNodeReader(n, parent)
 	case *orNode:
@@ -154,6 +220,11 @@ func (t *translator) toReader(node, parent node.Node) io.Reader {
 		return t.newBinaryOpNodeReader(n, parent, "+")
 	case *subtractNode:
 		return t.newBinaryOpNodeReader(n, parent, "-")
+	case *concatNode:
+		if t.target == targetVim80 {
+			return t.newBinaryOpNodeReader(n, parent, ".")
+		}
+		return t.newBinaryOpNodeReader(n, parent, "..")
 	case *multiplyNode:
 		return t.newBinaryOpNodeReader(n, parent, "*")
 	case *divideNode:
@@ -180,6 +251,10 @@ func (t *translator) toReader(node, parent node.Node) io.Reader {
 		return t.newIntNodeReader(n, parent)
 	case *floatNode:
 		return t.newFloatNodeReader(n, parent)
+	case *boolNode:
+		return t.newBoolNodeReader(n, parent)
+	case *noneNode:
+		return t.newNoneNodeReader(n, parent)
 	case *stringNode:
 		return t.newStringNodeReader(n, parent)
 	case *listNode:
@@ -200,6 +275,25 @@ func (t *translator) toReader(node, parent node.Node) io.Reader {
 }
 
 func (t *translator) newTopLevelNodeReader(node *topLevelNode) io.Reader {
+	// Enum variants can be referred before the declaration.
+	for i := range node.body {
+		if e, ok := node.body[i].TerminalNode().(*enumStatement); ok {
+			t.enums[e.typ.name] = e.typ
+		}
+	}
+	// Functions can be referred before the declaration.
+	for i := range node.body {
+		f, ok := node.body[i].TerminalNode().(*funcStmtOrExpr)
+		if !ok || f.isExpr || f.declare.name == "" {
+			continue
+		}
+		autoload, global, _ := t.convertModifiers(f.declare.mods)
+		t.funcNames[f.declare.name] = t.getFuncName(f, autoload, global)
+		// Functions in autoload directory are called by the autoload function names.
+		if t.autoloadPrefix != "" && !global {
+			t.importedFuncs[f.declare.name] = t.autoloadPrefix + f.declare.name
+		}
+	}
 	var buf bytes.Buffer
 	for i := range node.body {
 		if i > 0 {
@@ -208,15 +302,96 @@ func (t *translator) newTopLevelNodeReader(node *topLevelNode) io.Reader {
 		buf.WriteString(t.indent())
 		_, err := io.Copy(&buf, t.toExcmd(node.body[i], node))
 		if err != nil {
-			return t.err(err, node)
+			return t.err(err, node.body[i])
 		}
 	}
 	return strings.NewReader(buf.String())
 }
 
 func (t *translator) newImportStatementReader(stmt *importStatement, parent node.Node) io.Reader {
-	// TODO
-	return emptyReader
+	prefix, err := t.getFuncPrefix(stmt)
+	if err != nil {
+		return t.err(err, stmt)
+	}
+	// import {pkg} [as {alias}]
+	if len(stmt.fnlist) == 0 {
+		t.importedPkgs[stmt.pkgName()] = prefix
+		return emptyReader
+	}
+	// from {pkg} import {orig} [as {name}], ...
+	var buf bytes.Buffer
+	for _, pair := range stmt.importedNames() {
+		orig, name := pair[0], pair[1]
+		if stmt.isBuiltin() && orig == name {
+			continue // Builtin function can be called as it is.
+		}
+		if buf.Len() > 0 {
+			buf.WriteString("\n")
+			buf.WriteString(t.indent())
+		}
+		buf.WriteString(fmt.Sprintf("let s:%s = function('%s%s')", name, prefix, orig))
+		t.importedFuncs[name] = "s:" + name
+	}
+	return strings.NewReader(buf.String())
+}
+
+// getFuncPrefix returns the prefix of function names in the package.
+// Standard library functions are builtin functions ("$vim/ex" -> ""),
+// and other functions are autoload functions
+// ("autoload/foo/bar.vain" -> "foo#bar#").
+// The module must be in autoload directory to be imported.
+func (t *translator) getFuncPrefix(stmt *importStatement) (string, error) {
+	if stmt.isBuiltin() {
+		return "", nil
+	}
+	path, err := stmt.pkg.eval()
+	if err != nil {
+		return "", err
+	}
+	file, ok := t.modules[path]
+	if !ok {
+		return "", fmt.Errorf("module not found: '%s'", path)
+	}
+	prefix, err := getAutoloadPrefix(file)
+	if err != nil {
+		return "", fmt.Errorf("cannot import '%s': %s", path, err.Error())
+	}
+	return prefix, nil
+}
+
+// getAutoloadPrefix returns the prefix of autoload function names
+// in the file ("plugin/autoload/foo/bar.vain" -> "foo#bar#").
+// It returns an error if the file is not in autoload directory,
+// or the path can't be autoload function name.
+func getAutoloadPrefix(file string) (string, error) {
+	parts := strings.Split(filepath.ToSlash(filepath.Clean(file)), "/")
+	for i := len(parts) - 2; i >= 0; i-- {
+		if parts[i] != "autoload" {
+			continue
+		}
+		names := append([]string{}, parts[i+1:]...)
+		names[len(names)-1] = strings.TrimSuffix(names[len(names)-1], ".vain")
+		for _, name := range names {
+			if !isAutoloadName(name) {
+				return "", fmt.Errorf("%s is not valid autoload name", file)
+			}
+		}
+		return strings.Join(names, "#") + "#", nil
+	}
+	return "", fmt.Errorf("%s is not in autoload directory", file)
+}
+
+// isAutoloadName returns true if name can be a part of autoload function name.
+func isAutoloadName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if r != '_' && !('0' <= r && r <= '9' || 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z') {
+			return false
+		}
+	}
+	return true
 }
 
 func (t *translator) newFuncDeclareStatementReader(f *funcDeclareStatement, parent node.Node) io.Reader {
@@ -230,7 +405,7 @@ func (t *translator) newFuncReader(f *funcStmtOrExpr, parent node.Node) io.Reade
 		if f.declare.name != "" {
 			return t.newFuncStmtReader(f, "")
 		}
-		if len(f.body) == 0 {
+		if len(f.body) == 0 || hasDefaultArgs(f.declare) {
 			autoload, global, _ := t.convertModifiers(f.declare.mods)
 			name := t.getFuncName(f, autoload, global)
 			if name == "" {
@@ -242,7 +417,8 @@ func (t *translator) newFuncReader(f *funcStmtOrExpr, parent node.Node) io.Reade
 		return t.newLambdaReader(f, parent)
 	}
 	// Function expression is required.
-	if f.declare.name != "" || len(f.body) == 0 {
+	// Lambda can't have default arguments.
+	if f.declare.name != "" || len(f.body) == 0 || hasDefaultArgs(f.declare) {
 		autoload, global, _ := t.convertModifiers(f.declare.mods)
 		name := t.getFuncName(f, autoload, global)
 		if name == "" {
@@ -254,6 +430,16 @@ func (t *translator) newFuncReader(f *funcStmtOrExpr, parent node.Node) io.Reade
 	return t.newLambdaReader(f, parent)
 }
 
+// hasDefaultArgs returns true if f has one or more default arguments.
+func hasDefaultArgs(f *funcDeclareStatement) bool {
+	for i := range f.args {
+		if f.args[i].defaultVal != nil {
+			return true
+		}
+	}
+	return false
+}
+
 func (t *translator) isVoidExprFunc(f *funcStmtOrExpr, parent node.Node) bool {
 	if !f.IsExpr() {
 		// Function statement is required.
@@ -276,7 +462,9 @@ func (t *translator) getFuncName(f *funcStmtOrExpr, autoload, global bool) strin
 	if f.declare.name == "" {
 		return ""
 	}
-	if autoload {
+	if t.autoloadPrefix != "" && !global {
+		return t.autoloadPrefix + f.declare.name
+	} else if autoload {
 		return f.declare.name
 	} else if global {
 		// TODO Check if function name starts with uppercase letter in analyzer.
@@ -300,15 +488,48 @@ func (t *translator) newFuncStmtReader(f *funcStmtOrExpr, name string) io.Reader
 	buf.WriteString("function! ")
 	buf.WriteString(name)
 	buf.WriteString("(")
+	params := make([]string, 0, len(f.declare.args))
+	preambles := make([]string, 0, len(f.declare.args))
+	optional := 0 // The number of default arguments passed by "..."
 	for i := range f.declare.args {
-		if i > 0 {
-			buf.WriteString(",")
-		}
-		_, err := io.Copy(&buf, t.toExcmd(f.declare.args[i].left, f))
+		arg := &f.declare.args[i]
+		var left bytes.Buffer
+		_, err := io.Copy(&left, t.toReader(arg.left, f))
 		if err != nil {
-			return t.err(err, f.declare.args[i].left)
+			return t.err(err, arg.left)
+		}
+		switch {
+		case arg.variadic:
+			// Bind the variadic argument name to a:000 .
+			if optional == 0 {
+				params = append(params, "...")
+				preambles = append(preambles, fmt.Sprintf("let %s = a:000", left.String()))
+			} else {
+				preambles = append(preambles, fmt.Sprintf("let %s = a:000[%d:]", left.String(), optional))
+			}
+		case arg.defaultVal != nil:
+			var value bytes.Buffer
+			_, err := io.Copy(&value, t.toReader(arg.defaultVal, f))
+			if err != nil {
+				return t.err(err, arg.defaultVal)
+			}
+			if t.target >= targetVim82 {
+				params = append(params, fmt.Sprintf("%s = %s", left.String(), value.String()))
+				break
+			}
+			// Vim 8.0 does not support default argument syntax.
+			optional++
+			if optional == 1 {
+				params = append(params, "...")
+			}
+			preambles = append(preambles, fmt.Sprintf(
+				"let %s = get(a:, %d, %s)", left.String(), optional, value.String(),
+			))
+		default:
+			params = append(params, left.String())
 		}
 	}
+	buf.WriteString(strings.Join(params, ","))
 	buf.WriteString(")")
 	if len(vimmods) > 0 {
 		buf.WriteString(" ")
@@ -316,9 +537,21 @@ func (t *translator) newFuncStmtReader(f *funcStmtOrExpr, name string) io.Reader
 	}
 	buf.WriteString("\n")
 	t.incIndent()
+	for i := range preambles {
+		buf.WriteString(t.indent())
+		buf.WriteString(preambles[i])
+		buf.WriteString("\n")
+	}
 	for i := range f.body {
 		buf.WriteString(t.indent())
-		_, err := io.Copy(&buf, t.toExcmd(f.body[i], f))
+		r := t.toExcmd(f.body[i], f)
+		if f.isArrow {
+			// Arrow lambda returns the value of the body
+			// (e.g. "(a, b = 1) -> a + b" has default argument).
+			buf.WriteString("return ")
+			r = t.toReader(f.body[i], f)
+		}
+		_, err := io.Copy(&buf, r)
 		if err != nil {
 			return t.err(err, f.body[i])
 		}
@@ -337,6 +570,15 @@ func (t *translator) newLambdaReader(f *funcStmtOrExpr, parent node.Node) io.Rea
 		if i > 0 {
 			buf.WriteString(",")
 		}
+		if f.declare.args[i].variadic {
+			// Lambda can't have statements, so replace the references to a:000 .
+			if id, ok := f.declare.args[i].left.TerminalNode().(*identifierNode); ok {
+				t.lambdaArgs[id.value] = "a:000"
+				defer delete(t.lambdaArgs, id.value)
+			}
+			buf.WriteString("...")
+			continue
+		}
 		_, err := io.Copy(&buf, t.toExcmd(f.declare.args[i].left, f))
 		if err != nil {
 			return t.err(err, f.declare.args[i].left)
@@ -457,17 +699,34 @@ func (t *translator) newWhileStatementReader(node *whileStatement, parent node.N
 func (t *translator) newForStatementReader(node *forStatement, parent node.Node) io.Reader {
 	var buf bytes.Buffer
 	buf.WriteString("for ")
-	_, err := io.Copy(&buf, t.toReader(node.left, parent))
-	if err != nil {
-		return t.err(err, node.left)
+	var lets []string
+	var tmpVar string
+	if dict, ok := node.left.(*dictionaryNode); ok {
+		// for {name, path: p} in list -> for _dict{nr} in list | let name = _dict{nr}.name | ...
+		// for {key, value} in items(d) -> for _dict{nr} in items(d) | let key = _dict{nr}[0] | ...
+		tmpVar = t.newTmpVar("_dict")
+		var err error
+		lets, err = t.getDictDestructuringLets(dict, tmpVar, node.pairs)
+		if err != nil {
+			return t.err(err, node.left)
+		}
+		buf.WriteString(tmpVar)
+	} else {
+		_, err := io.Copy(&buf, t.toReader(node.left, parent))
+		if err != nil {
+			return t.err(err, node.left)
+		}
 	}
 	buf.WriteString(" in ")
-	_, err = io.Copy(&buf, t.toReader(node.right, parent))
+	_, err := io.Copy(&buf, t.toReader(node.right, parent))
 	if err != nil {
 		return t.err(err, node.right)
 	}
 	buf.WriteString("\n")
 	t.incIndent()
+	for i := range lets {
+		buf.WriteString(t.indent() + lets[i] + "\n")
+	}
 	for i := range node.body {
 		buf.WriteString(t.indent())
 		_, err = io.Copy(&buf, t.toReader(node.body[i], node))
@@ -479,9 +738,415 @@ func (t *translator) newForStatementReader(node *forStatement, parent node.Node)
 	t.decIndent()
 	buf.WriteString(t.indent())
 	buf.WriteString("endfor")
+	if tmpVar != "" {
+		// "unlet!" because tmpVar is not defined if list is empty.
+		buf.WriteString("\n" + t.indent() + "unlet! " + tmpVar)
+	}
+	return strings.NewReader(buf.String())
+}
+
+func (t *translator) newTryStatementReader(n *tryStatement, parent node.Node) io.Reader {
+	var buf bytes.Buffer
+	writeBody := func(body []node.Node) io.Reader {
+		t.incIndent()
+		defer t.decIndent()
+		for i := range body {
+			buf.WriteString(t.indent())
+			_, err := io.Copy(&buf, t.toReader(body[i], n))
+			if err != nil {
+				return t.err(err, body[i])
+			}
+			buf.WriteString("\n")
+		}
+		return nil
+	}
+	buf.WriteString("try\n")
+	if r := writeBody(n.body); r != nil {
+		return r
+	}
+	for i := range n.catches {
+		buf.WriteString(t.indent())
+		buf.WriteString("catch")
+		if n.catches[i].pattern != "" {
+			buf.WriteString(" ")
+			buf.WriteString(n.catches[i].pattern)
+		}
+		buf.WriteString("\n")
+		if n.catches[i].left != nil {
+			var left bytes.Buffer
+			_, err := io.Copy(&left, t.toReader(n.catches[i].left, n))
+			if err != nil {
+				return t.err(err, n.catches[i].left)
+			}
+			buf.WriteString(t.indent() + t.indentStr)
+			buf.WriteString("let " + left.String() + " = v:exception\n")
+		}
+		if r := writeBody(n.catches[i].body); r != nil {
+			return r
+		}
+	}
+	if n.hasFinally {
+		buf.WriteString(t.indent())
+		buf.WriteString("finally\n")
+		if r := writeBody(n.finally); r != nil {
+			return r
+		}
+	}
+	buf.WriteString(t.indent())
+	buf.WriteString("endtry")
+	return strings.NewReader(buf.String())
+}
+
+// newMatchStatementReader converts the match statement to if-elseif chain.
+// If the subject is not a variable, it is evaluated once into a temporary variable.
+func (t *translator) newMatchStatementReader(n *matchStatement, parent node.Node) io.Reader {
+	var subject bytes.Buffer
+	_, err := io.Copy(&subject, t.toReader(n.left, n))
+	if err != nil {
+		return t.err(err, n.left)
+	}
+	var buf bytes.Buffer
+	v := subject.String()
+	tmpVar := ""
+	if !isVarRef(n.left) {
+		tmpVar = t.newTmpVar("_match")
+		buf.WriteString("let " + tmpVar + " = " + v + "\n" + t.indent())
+		v = tmpVar
+	}
+
+	hasElse := false
+	for i := range n.arms {
+		conds, binds, err := t.getPatternCondition(n.arms[i].pattern, v)
+		if err != nil {
+			return t.err(err, n.arms[i].pattern)
+		}
+		switch {
+		case i == 0 && len(conds) == 0:
+			buf.WriteString("if 1\n")
+		case len(conds) == 0:
+			buf.WriteString(t.indent() + "else\n")
+			hasElse = true
+		case i == 0:
+			buf.WriteString("if " + strings.Join(conds, " && ") + "\n")
+		default:
+			buf.WriteString(t.indent() + "elseif " + strings.Join(conds, " && ") + "\n")
+		}
+		t.incIndent()
+		for j := range binds {
+			buf.WriteString(t.indent() + binds[j] + "\n")
+		}
+		for j := range n.arms[i].body {
+			buf.WriteString(t.indent())
+			_, err := io.Copy(&buf, t.toReader(n.arms[i].body[j], n))
+			if err != nil {
+				t.decIndent()
+				return t.err(err, n.arms[i].body[j])
+			}
+			buf.WriteString("\n")
+		}
+		t.decIndent()
+		if hasElse {
+			break // the rest arms are unreachable
+		}
+	}
+	buf.WriteString(t.indent() + "endif")
+	if tmpVar != "" {
+		buf.WriteString("\n" + t.indent() + "unlet " + tmpVar)
+	}
+	return strings.NewReader(buf.String())
+}
+
+// getPatternCondition returns the conditions to match the pattern with
+// the value of Vim script expression v, and the :let commands to bind
+// the variables in the pattern.
+func (t *translator) getPatternCondition(pattern node.Node, v string) ([]string, []string, error) {
+	typeIs := func(typ string) string {
+		return "type(" + v + ") == " + typ
+	}
+	switch p := pattern.(type) {
+	case *identifierNode:
+		if p.value == "_" {
+			return nil, nil, nil
+		}
+		var name bytes.Buffer
+		_, err := io.Copy(&name, t.toReader(p, nil))
+		if err != nil {
+			return nil, nil, err
+		}
+		return nil, []string{"let " + name.String() + " = " + v}, nil
+	case *intNode:
+		return []string{typeIs("v:t_number"), v + " == " + p.value}, nil, nil
+	case *floatNode:
+		return []string{typeIs("v:t_float"), v + " == " + p.value}, nil, nil
+	case *stringNode:
+		return []string{typeIs("v:t_string"), v + " ==# " + string(p.value)}, nil, nil
+	case *boolNode:
+		if p.value {
+			return []string{v + " is v:true"}, nil, nil
+		}
+		return []string{v + " is v:false"}, nil, nil
+	case *noneNode:
+		return []string{v + " is v:null"}, nil, nil
+	case *dotNode:
+		enum, ok := p.left.(*identifierNode)
+		id, ok2 := p.right.(*identifierNode)
+		if !ok || !ok2 || t.enums[enum.value] == nil {
+			return nil, nil, errors.New("invalid pattern: must be the variant of enum")
+		}
+		name := enumVarName(enum.value, id.value)
+		if t.enums[enum.value].isString {
+			return []string{typeIs("v:t_string"), v + " ==# " + name}, nil, nil
+		}
+		return []string{typeIs("v:t_number"), v + " == " + name}, nil, nil
+	case *listNode:
+		elems := p.value
+		conds := []string{typeIs("v:t_list"), fmt.Sprintf("len(%s) == %d", v, len(elems))}
+		if p.rest {
+			// [a, ...rest] matches the list which has one or more elements.
+			elems = elems[:len(elems)-1]
+			conds[1] = fmt.Sprintf("len(%s) >= %d", v, len(elems))
+		}
+		var binds []string
+		for i := range elems {
+			c, b, err := t.getPatternCondition(elems[i], fmt.Sprintf("%s[%d]", v, i))
+			if err != nil {
+				return nil, nil, err
+			}
+			conds = append(conds, c...)
+			binds = append(binds, b...)
+		}
+		if p.rest {
+			_, b, err := t.getPatternCondition(p.value[len(elems)], fmt.Sprintf("%s[%d:]", v, len(elems)))
+			if err != nil {
+				return nil, nil, err
+			}
+			binds = append(binds, b...)
+		}
+		return conds, binds, nil
+	case *dictionaryNode:
+		conds := []string{typeIs("v:t_dict")}
+		var binds []string
+		for i := range p.value {
+			key, ok := p.value[i][0].(*identifierNode)
+			if !ok {
+				return nil, nil, errors.New("fatal: the key of dictionary pattern must be identifier")
+			}
+			conds = append(conds, fmt.Sprintf("has_key(%s, '%s')", v, key.value))
+			c, b, err := t.getPatternCondition(p.value[i][1], v+"."+key.value)
+			if err != nil {
+				return nil, nil, err
+			}
+			conds = append(conds, c...)
+			binds = append(binds, b...)
+		}
+		return conds, binds, nil
+	}
+	return nil, nil, fmt.Errorf("unknown pattern: %+v", pattern)
+}
+
+func (t *translator) newThrowStatementReader(node *throwStatement, parent node.Node) io.Reader {
+	var value bytes.Buffer
+	_, err := io.Copy(&value, t.toReader(node.left, parent))
+	if err != nil {
+		return t.err(err, node.left)
+	}
+	s := fmt.Sprintf("throw %s", t.paren(value.String(), node.left))
+	return strings.NewReader(s)
+}
+
+func (t *translator) newVimBlockStatementReader(node *vimBlockStatement, parent node.Node) io.Reader {
+	var buf bytes.Buffer
+	for i := range node.lines {
+		if i > 0 {
+			buf.WriteString("\n")
+			if node.lines[i] != "" {
+				buf.WriteString(t.indent())
+			}
+		}
+		buf.WriteString(node.lines[i])
+	}
+	return strings.NewReader(buf.String())
+}
+
+func (t *translator) newExecuteStatementReader(node *executeStatement, parent node.Node) io.Reader {
+	var value bytes.Buffer
+	_, err := io.Copy(&value, t.toReader(node.left, node))
+	if err != nil {
+		return t.err(err, node.left)
+	}
+	return strings.NewReader("execute " + value.String())
+}
+
+// getCallbackName returns the function name of the callback of Ex command.
+// If sid is true, returns the name which can be used in mappings.
+func (t *translator) getCallbackName(callback node.Node, sid bool) (string, error) {
+	id, ok := callback.TerminalNode().(*identifierNode)
+	if !ok {
+		return "", fmt.Errorf("callback must be a function name")
+	}
+	name, ok := t.funcNames[id.value]
+	if !ok {
+		if name, ok := t.importedFuncs[id.value]; ok {
+			if sid {
+				// The variable of Funcref can't be referred in mappings.
+				return "", fmt.Errorf("cannot use imported function %s as map callback", id.value)
+			}
+			return name, nil
+		}
+		name = "s:" + id.value
+	}
+	if sid && strings.HasPrefix(name, "s:") {
+		return "<SID>" + name[len("s:"):], nil
+	}
+	return name, nil
+}
+
+// escapeMapArg escapes the special characters in {lhs} of mappings.
+func escapeMapArg(s string) string {
+	s = strings.Replace(s, "|", "<Bar>", -1)
+	return strings.Replace(s, " ", "<Space>", -1)
+}
+
+func (t *translator) newMapStatementReader(node *mapStatement, parent node.Node) io.Reader {
+	lhs, err := node.lhs.eval()
+	if err != nil {
+		return t.err(err, node.callback)
+	}
+	name, err := t.getCallbackName(node.callback, true)
+	if err != nil {
+		return t.err(err, node.callback)
+	}
+	cmd := "noremap"
+	var attrs string
+	isExpr := false
+	for i := range node.options {
+		switch node.options[i].name {
+		case "remap":
+			cmd = "map"
+		case "expr":
+			isExpr = true
+			fallthrough
+		default:
+			attrs += " <" + node.options[i].name + ">"
+		}
+	}
+	lines := make([]string, 0, len(node.modes))
+	for _, mode := range node.modes {
+		var rhs string
+		switch {
+		case isExpr:
+			rhs = name + "()"
+		case mode == 'i':
+			rhs = "<C-o>:call " + name + "()<CR>"
+		default:
+			rhs = ":<C-u>call " + name + "()<CR>"
+		}
+		lines = append(lines, fmt.Sprintf("%c%s%s %s %s", mode, cmd, attrs, escapeMapArg(lhs), rhs))
+	}
+	return strings.NewReader(strings.Join(lines, "\n"+t.indent()))
+}
+
+func (t *translator) newCommandStatementReader(node *commandStatement, parent node.Node) io.Reader {
+	name, err := t.getCallbackName(node.callback, false)
+	if err != nil {
+		return t.err(err, node.callback)
+	}
+	var buf bytes.Buffer
+	buf.WriteString("command!")
+	for i := range node.options {
+		buf.WriteString(" -" + node.options[i].name)
+		if value := node.options[i].eval(); value != "" {
+			buf.WriteString("=" + value)
+		}
+	}
+	buf.WriteString(" " + node.name + " call " + name)
+	if node.nargs() == "0" {
+		buf.WriteString("()")
+	} else {
+		buf.WriteString("(<f-args>)")
+	}
+	return strings.NewReader(buf.String())
+}
+
+func (t *translator) newAutocmdStatementReader(node *autocmdStatement, parent node.Node) io.Reader {
+	pattern, err := node.pattern.eval()
+	if err != nil {
+		return t.err(err, node.callback)
+	}
+	name, err := t.getCallbackName(node.callback, false)
+	if err != nil {
+		return t.err(err, node.callback)
+	}
+	var buf bytes.Buffer
+	buf.WriteString("autocmd ")
+	buf.WriteString(strings.Join(node.events, ","))
+	buf.WriteString(" " + escapeMapArg(pattern))
+	for i := range node.options {
+		switch {
+		case t.target != targetVim80:
+			buf.WriteString(" ++" + node.options[i].name)
+		case node.options[i].name == "nested":
+			buf.WriteString(" nested")
+		default:
+			return t.err(fmt.Errorf("-%s option of autocmd requires --target=vim8.2", node.options[i].name), node.callback)
+		}
+	}
+	buf.WriteString(" call " + name + "()")
+	return strings.NewReader(buf.String())
+}
+
+func (t *translator) newAugroupStatementReader(node *augroupStatement, parent node.Node) io.Reader {
+	var buf bytes.Buffer
+	buf.WriteString("augroup " + node.name + "\n")
+	t.incIndent()
+	buf.WriteString(t.indent() + "autocmd!\n")
+	for i := range node.body {
+		buf.WriteString(t.indent())
+		_, err := io.Copy(&buf, t.toReader(node.body[i], node))
+		if err != nil {
+			t.decIndent()
+			return t.err(err, node.body[i])
+		}
+		buf.WriteString("\n")
+	}
+	t.decIndent()
+	buf.WriteString(t.indent() + "augroup END")
 	return strings.NewReader(buf.String())
 }
 
+// newEnumStatementReader declares the script-local constant for each variant.
+// Vim 8.0 does not have :const, so the variables are locked by :lockvar.
+func (t *translator) newEnumStatementReader(node *enumStatement, parent node.Node) io.Reader {
+	var buf bytes.Buffer
+	names := make([]string, len(node.typ.variants))
+	for i, v := range node.typ.variants {
+		names[i] = enumVarName(node.typ.name, v.name)
+		if i > 0 {
+			buf.WriteString("\n" + t.indent())
+		}
+		if t.target >= targetVim82 {
+			buf.WriteString("const " + names[i] + " = " + v.value)
+		} else {
+			buf.WriteString("let " + names[i] + " = " + v.value)
+		}
+	}
+	if t.target < targetVim82 {
+		buf.WriteString("\n" + t.indent() + "lockvar " + strings.Join(names, " "))
+	}
+	return strings.NewReader(buf.String())
+}
+
+// enumVarName returns the variable name of the enum variant.
+func enumVarName(enum, variant string) string {
+	return "s:" + enum + "_" + variant
+}
+
+// newTypeDeclareStatementReader erases the type declaration.
+// The value of record type is a plain dictionary.
+func (t *translator) newTypeDeclareStatementReader(node *typeDeclareStatement, parent node.Node) io.Reader {
+	return emptyReader
+}
+
 func (t *translator) newReturnNodeReader(node *returnStatement, parent node.Node) io.Reader {
 	if node.left == nil {
 		return strings.NewReader("return")
@@ -496,13 +1161,24 @@ func (t *translator) newReturnNodeReader(node *returnStatement, parent node.Node
 }
 
 func (t *translator) newAssignStatementReader(node assignNode, parent node.Node) io.Reader {
+	if dict, ok := node.Left().(*dictionaryNode); ok {
+		return t.newDictDestructuringReader(dict, node.Right(), parent)
+	}
 	var buf bytes.Buffer
 	buf.WriteString("let ")
 	_, err := io.Copy(&buf, t.toReader(node.Left(), parent))
 	if err != nil {
 		return t.err(err, node.Left())
 	}
-	buf.WriteString(" = ")
+	if c, ok := node.(*compoundAssignExpr); ok {
+		op := c.op
+		if op == "..=" && t.target == targetVim80 {
+			op = ".=" // "..=" is not supported before Vim 8.1.1114
+		}
+		buf.WriteString(" " + op + " ")
+	} else {
+		buf.WriteString(" = ")
+	}
 	_, err = io.Copy(&buf, t.toReader(node.Right(), parent))
 	if err != nil {
 		return t.err(err, node.Right())
@@ -510,6 +1186,79 @@ func (t *translator) newAssignStatementReader(node assignNode, parent node.Node)
 	return strings.NewReader(buf.String())
 }
 
+// newDictDestructuringReader converts "{name, path: p} = opts" to
+// "let name = opts.name" and "let p = opts.path".
+// If right has side effects, it is evaluated once into a temporary variable.
+func (t *translator) newDictDestructuringReader(left *dictionaryNode, right, parent node.Node) io.Reader {
+	var value bytes.Buffer
+	_, err := io.Copy(&value, t.toReader(right, parent))
+	if err != nil {
+		return t.err(err, right)
+	}
+	var buf bytes.Buffer
+	v := value.String()
+	tmpVar := ""
+	if !isVarRef(right) {
+		tmpVar = t.newTmpVar("_dict")
+		buf.WriteString("let " + tmpVar + " = " + v + "\n" + t.indent())
+		v = tmpVar
+	}
+	lets, err := t.getDictDestructuringLets(left, v, false)
+	if err != nil {
+		return t.err(err, left)
+	}
+	buf.WriteString(strings.Join(lets, "\n"+t.indent()))
+	if tmpVar != "" {
+		buf.WriteString("\n" + t.indent() + "unlet " + tmpVar)
+	}
+	return strings.NewReader(buf.String())
+}
+
+// getDictDestructuringLets returns :let commands which assign
+// the values of Vim script expression v to the variables.
+// If pairs is true, v is [key, value] pair (e.g. the element of items()).
+func (t *translator) getDictDestructuringLets(left *dictionaryNode, v string, pairs bool) ([]string, error) {
+	lets := make([]string, 0, len(left.value))
+	for i := range left.value {
+		key, ok := left.value[i][0].(*identifierNode)
+		if !ok {
+			return nil, errors.New("fatal: the key of dictionary destructuring must be identifier")
+		}
+		var name bytes.Buffer
+		_, err := io.Copy(&name, t.toReader(left.value[i][1], left))
+		if err != nil {
+			return nil, err
+		}
+		field := "." + key.value
+		if pairs && key.value == "key" {
+			field = "[0]"
+		} else if pairs {
+			field = "[1]"
+		}
+		lets = append(lets, "let "+name.String()+" = "+v+field)
+	}
+	return lets, nil
+}
+
+// newTmpVar returns the unique name of temporary variable.
+func (t *translator) newTmpVar(prefix string) string {
+	name := prefix + strconv.Itoa(t.tmpVarID)
+	t.tmpVarID++
+	return name
+}
+
+// isVarRef returns true if n is a variable or the field access of it
+// (e.g. "a", "a.b.c"), which can be evaluated many times without side effects.
+func isVarRef(n node.Node) bool {
+	switch nn := n.(type) {
+	case *identifierNode:
+		return true
+	case *dotNode:
+		return isVarRef(nn.left)
+	}
+	return false
+}
+
 func (t *translator) newLetDeclareStatementReader(node *letDeclareStatement, parent node.Node) io.Reader {
 	return emptyReader // TODO
 }
@@ -631,6 +1380,20 @@ func (t *translator) newSubscriptNodeReader(node *subscriptNode, parent node.Nod
 }
 
 func (t *translator) newDotNodeReader(node *dotNode, parent node.Node) io.Reader {
+	// {pkg}.{func} -> {prefix}{func}
+	if pkg, ok := node.left.(*identifierNode); ok {
+		if prefix, ok := t.importedPkgs[pkg.value]; ok {
+			if id, ok := node.right.(*identifierNode); ok {
+				return strings.NewReader(prefix + id.value)
+			}
+		}
+	}
+	// {enum}.{variant} -> s:{enum}_{variant}
+	if enum, ok := node.left.(*identifierNode); ok && t.enums[enum.value] != nil {
+		if id, ok := node.right.(*identifierNode); ok {
+			return strings.NewReader(enumVarName(enum.value, id.value))
+		}
+	}
 	var left bytes.Buffer
 	_, err := io.Copy(&left, t.toReader(node.left, parent))
 	if err != nil {
@@ -648,6 +1411,12 @@ func (t *translator) newDotNodeReader(node *dotNode, parent node.Node) io.Reader
 }
 
 func (t *translator) newIdentifierNodeReader(node *identifierNode, parent node.Node) io.Reader {
+	if name, ok := t.lambdaArgs[node.value]; ok && node.isVarname {
+		return strings.NewReader(name)
+	}
+	if name, ok := t.importedFuncs[node.value]; ok && node.isVarname {
+		return strings.NewReader(name)
+	}
 	return strings.NewReader(node.value)
 }
 
@@ -659,10 +1428,47 @@ func (t *translator) newFloatNodeReader(node *floatNode, parent node.Node) io.Re
 	return strings.NewReader(node.value)
 }
 
+func (t *translator) newBoolNodeReader(node *boolNode, parent node.Node) io.Reader {
+	if node.value {
+		return strings.NewReader("v:true")
+	}
+	return strings.NewReader("v:false")
+}
+
+func (t *translator) newNoneNodeReader(node *noneNode, parent node.Node) io.Reader {
+	return strings.NewReader("v:null")
+}
+
 func (t *translator) newStringNodeReader(node *stringNode, parent node.Node) io.Reader {
 	return strings.NewReader(string(node.value))
 }
 
+func (t *translator) newTemplateNodeReader(node *templateNode, parent node.Node) io.Reader {
+	parts := make([]string, 0, len(node.lits)+len(node.exprs))
+	for i := range node.lits {
+		if node.lits[i] != "" {
+			parts = append(parts, node.quote+node.lits[i]+node.quote)
+		}
+		if i < len(node.exprs) {
+			var value bytes.Buffer
+			_, err := io.Copy(&value, t.toReader(node.exprs[i], node))
+			if err != nil {
+				return t.err(err, node.exprs[i])
+			}
+			if node.raw[i] {
+				parts = append(parts, t.paren(value.String(), node.exprs[i]))
+			} else {
+				parts = append(parts, "fnameescape("+value.String()+")")
+			}
+		}
+	}
+	op := " .. "
+	if t.target == targetVim80 {
+		op = " . "
+	}
+	return strings.NewReader(strings.Join(parts, op))
+}
+
 func (t *translator) newLiteralNodeReader(node literalNode, parent node.Node, opstr string) io.Reader {
 	return strings.NewReader(opstr + node.Value())
 }
@@ -677,6 +1483,12 @@ func (t *translator) newListNodeReader(node *listNode, parent node.Node) io.Read
 		}
 		args = append(args, arg.String())
 	}
+	if node.rest {
+		// [a, ...b] -> [a; b]
+		last := len(args) - 1
+		s := "[" + strings.Join(args[:last], ",") + "; " + args[last] + "]"
+		return strings.NewReader(s)
+	}
 	s := "[" + strings.Join(args, ",") + "]"
 	return strings.NewReader(s)
 }
@@ -742,6 +1554,32 @@ func (t *translator) needsParen(node node.Node) bool {
 		return false
 	case *returnStatement:
 		return false
+	case *tryStatement:
+		return false
+	case *matchStatement:
+		return false
+	case *throwStatement:
+		return false
+	case *breakStatement:
+		return false
+	case *vimBlockStatement:
+		return false
+	case *executeStatement:
+		return false
+	case *mapStatement:
+		return false
+	case *commandStatement:
+		return false
+	case *autocmdStatement:
+		return false
+	case *augroupStatement:
+		return false
+	case *typeDeclareStatement:
+		return false
+	case *enumStatement:
+		return false
+	case *continueStatement:
+		return false
 	case *ternaryNode:
 		return true
 	case *orNode:
@@ -792,6 +1630,8 @@ func (t *translator) needsParen(node node.Node) bool {
 		return true
 	case *subtractNode:
 		return true
+	case *concatNode:
+		return true
 	case *multiplyNode:
 		return true
 	case *divideNode:
@@ -818,6 +1658,10 @@ func (t *translator) needsParen(node node.Node) bool {
 		return false
 	case *floatNode:
 		return false
+	case *boolNode:
+		return false
+	case *noneNode:
+		return false
 	case *stringNode:
 		return false
 	case *listNode: